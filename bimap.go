@@ -0,0 +1,362 @@
+package genh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+)
+
+var ErrBiMapConflict = errors.New("genh: BiMap conflict")
+
+// BiConflict decides what BiMap.Set does when the key or the value is already used by another pair.
+type BiConflict uint8
+
+const (
+	// BiReplace removes the existing pairs that use the key or the value, it's the default.
+	BiReplace BiConflict = iota
+	// BiReject leaves the map as is and Set returns false.
+	BiReject
+	// BiError leaves the map as is and Set returns an error wrapping ErrBiMapConflict.
+	BiError
+)
+
+func NewBiMap[K, V comparable](sz int, policy BiConflict) *BiMap[K, V] {
+	return &BiMap[K, V]{kv: make(map[K]V, sz), vk: make(map[V]K, sz), policy: policy}
+}
+
+// BiMapOf returns a BiMap of m, it returns an error if m has duplicate values.
+func BiMapOf[K, V comparable](m map[K]V) (*BiMap[K, V], error) {
+	bm := NewBiMap[K, V](len(m), BiError)
+	for k, v := range m {
+		if _, err := bm.Set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	bm.policy = BiReplace
+	return bm, nil
+}
+
+// BiMap is a one-to-one map, every key maps to a unique value and every value maps back to its key.
+// The zero value is ready to use with the BiReplace policy.
+type BiMap[K, V comparable] struct {
+	kv     map[K]V
+	vk     map[V]K
+	policy BiConflict
+}
+
+func (bm *BiMap[K, V]) SetConflictPolicy(p BiConflict) {
+	bm.policy = p
+}
+
+// Set maps k to v and v to k, if either is already used by another pair, the conflict policy decides what happens.
+// It returns true if the pair was set.
+func (bm *BiMap[K, V]) Set(k K, v V) (bool, error) {
+	ov, kok := bm.kv[k]
+	ok, vok := bm.vk[v]
+	if kok && ov == v {
+		return true, nil
+	}
+	if kok || vok {
+		switch bm.policy {
+		case BiReject:
+			return false, nil
+		case BiError:
+			if kok {
+				return false, fmt.Errorf("%w: key %v is mapped to %v", ErrBiMapConflict, k, ov)
+			}
+			return false, fmt.Errorf("%w: value %v is mapped to %v", ErrBiMapConflict, v, ok)
+		}
+		if kok {
+			delete(bm.vk, ov)
+		}
+		if vok {
+			delete(bm.kv, ok)
+		}
+	}
+	if bm.kv == nil {
+		bm.kv, bm.vk = make(map[K]V), make(map[V]K)
+	}
+	bm.kv[k], bm.vk[v] = v, k
+	return true, nil
+}
+
+func (bm *BiMap[K, V]) GetByKey(k K) (v V, ok bool) {
+	v, ok = bm.kv[k]
+	return
+}
+
+func (bm *BiMap[K, V]) GetByValue(v V) (k K, ok bool) {
+	k, ok = bm.vk[v]
+	return
+}
+
+func (bm *BiMap[K, V]) HasKey(k K) bool {
+	_, ok := bm.kv[k]
+	return ok
+}
+
+func (bm *BiMap[K, V]) HasValue(v V) bool {
+	_, ok := bm.vk[v]
+	return ok
+}
+
+func (bm *BiMap[K, V]) DeleteByKey(k K) (v V, ok bool) {
+	if v, ok = bm.kv[k]; ok {
+		delete(bm.kv, k)
+		delete(bm.vk, v)
+	}
+	return
+}
+
+func (bm *BiMap[K, V]) DeleteByValue(v V) (k K, ok bool) {
+	if k, ok = bm.vk[v]; ok {
+		delete(bm.vk, v)
+		delete(bm.kv, k)
+	}
+	return
+}
+
+func (bm *BiMap[K, V]) Keys() []K {
+	return MapKeys(bm.kv)
+}
+
+func (bm *BiMap[K, V]) Values() []V {
+	return MapKeys(bm.vk)
+}
+
+// All returns an iterator over the pairs, the map must not be modified during the loop.
+func (bm *BiMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v := range bm.kv {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a copy of the key -> value map.
+func (bm *BiMap[K, V]) Clone() map[K]V {
+	return MapClone(bm.kv)
+}
+
+// Inverse returns a copy of the value -> key map.
+func (bm *BiMap[K, V]) Inverse() map[V]K {
+	return MapClone(bm.vk)
+}
+
+func (bm *BiMap[K, V]) Len() int {
+	return len(bm.kv)
+}
+
+func (bm *BiMap[K, V]) Clear() {
+	clear(bm.kv)
+	clear(bm.vk)
+}
+
+// setMap adds all the pairs of m using the conflict policy,
+// with BiError they're added to a copy that only replaces bm if none of them conflicts.
+func (bm *BiMap[K, V]) setMap(m map[K]V) error {
+	nbm := bm
+	if bm.policy == BiError {
+		nbm = &BiMap[K, V]{kv: MapClone(bm.kv), vk: MapClone(bm.vk), policy: bm.policy}
+	}
+	for k, v := range m {
+		if _, err := nbm.Set(k, v); err != nil {
+			return err
+		}
+	}
+	*bm = *nbm
+	return nil
+}
+
+// MarshalJSON encodes the map as a key -> value json object.
+func (bm *BiMap[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bm.kv)
+}
+
+// UnmarshalJSON adds the pairs of a key -> value json object using the conflict policy,
+// with BiError the map is left as is if any of the pairs conflicts.
+func (bm *BiMap[K, V]) UnmarshalJSON(p []byte) error {
+	var m map[K]V
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	return bm.setMap(m)
+}
+
+func (bm *BiMap[K, V]) MarshalBinary() ([]byte, error) {
+	return MarshalMsgpack(bm.kv)
+}
+
+func (bm *BiMap[K, V]) UnmarshalBinary(p []byte) error {
+	var m map[K]V
+	if err := UnmarshalMsgpack(p, &m); err != nil {
+		return err
+	}
+	return bm.setMap(m)
+}
+
+func NewLBiMap[K, V comparable](sz int, policy BiConflict) *LBiMap[K, V] {
+	return &LBiMap[K, V]{bm: *NewBiMap[K, V](sz, policy)}
+}
+
+// LBiMap is a locked BiMap, the zero value is ready to use with the BiReplace policy.
+type LBiMap[K, V comparable] struct {
+	bm  BiMap[K, V]
+	mux sync.RWMutex
+}
+
+func (lm *LBiMap[K, V]) SetConflictPolicy(p BiConflict) {
+	lm.mux.Lock()
+	lm.bm.policy = p
+	lm.mux.Unlock()
+}
+
+// Set maps k to v and v to k, if either is already used by another pair, the conflict policy decides what happens.
+// It returns true if the pair was set.
+func (lm *LBiMap[K, V]) Set(k K, v V) (bool, error) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.bm.Set(k, v)
+}
+
+func (lm *LBiMap[K, V]) GetByKey(k K) (v V, ok bool) {
+	lm.mux.RLock()
+	v, ok = lm.bm.kv[k]
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LBiMap[K, V]) GetByValue(v V) (k K, ok bool) {
+	lm.mux.RLock()
+	k, ok = lm.bm.vk[v]
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LBiMap[K, V]) HasKey(k K) bool {
+	_, ok := lm.GetByKey(k)
+	return ok
+}
+
+func (lm *LBiMap[K, V]) HasValue(v V) bool {
+	_, ok := lm.GetByValue(v)
+	return ok
+}
+
+func (lm *LBiMap[K, V]) DeleteByKey(k K) (v V, ok bool) {
+	lm.mux.Lock()
+	v, ok = lm.bm.DeleteByKey(k)
+	lm.mux.Unlock()
+	return
+}
+
+func (lm *LBiMap[K, V]) DeleteByValue(v V) (k K, ok bool) {
+	lm.mux.Lock()
+	k, ok = lm.bm.DeleteByValue(v)
+	lm.mux.Unlock()
+	return
+}
+
+func (lm *LBiMap[K, V]) Keys() (keys []K) {
+	lm.mux.RLock()
+	keys = lm.bm.Keys()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LBiMap[K, V]) Values() (values []V) {
+	lm.mux.RLock()
+	values = lm.bm.Values()
+	lm.mux.RUnlock()
+	return
+}
+
+// All returns an iterator over the pairs, the map is read-locked until the loop is done.
+func (lm *LBiMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		for k, v := range lm.bm.kv {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (lm *LBiMap[K, V]) Clone() (m map[K]V) {
+	lm.mux.RLock()
+	m = lm.bm.Clone()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LBiMap[K, V]) Inverse() (m map[V]K) {
+	lm.mux.RLock()
+	m = lm.bm.Inverse()
+	lm.mux.RUnlock()
+	return
+}
+
+// Update calls fn with the underlying BiMap while the map is write-locked.
+func (lm *LBiMap[K, V]) Update(fn func(bm *BiMap[K, V])) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	fn(&lm.bm)
+}
+
+// Read calls fn with the underlying BiMap while the map is read-locked, it must not be modified.
+func (lm *LBiMap[K, V]) Read(fn func(bm *BiMap[K, V])) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	fn(&lm.bm)
+}
+
+func (lm *LBiMap[K, V]) Len() (v int) {
+	lm.mux.RLock()
+	v = lm.bm.Len()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LBiMap[K, V]) Clear() {
+	lm.mux.Lock()
+	lm.bm.Clear()
+	lm.mux.Unlock()
+}
+
+func (lm *LBiMap[K, V]) MarshalJSON() ([]byte, error) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.bm.MarshalJSON()
+}
+
+func (lm *LBiMap[K, V]) UnmarshalJSON(p []byte) error {
+	var m map[K]V
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.bm.setMap(m)
+}
+
+func (lm *LBiMap[K, V]) MarshalBinary() ([]byte, error) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.bm.MarshalBinary()
+}
+
+func (lm *LBiMap[K, V]) UnmarshalBinary(p []byte) error {
+	var m map[K]V
+	if err := UnmarshalMsgpack(p, &m); err != nil {
+		return err
+	}
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.bm.setMap(m)
+}
@@ -0,0 +1,321 @@
+package genh
+
+// btDegree is the minimum degree of the btree, every node except the root has between btDegree-1 and 2*btDegree-1 items.
+const (
+	btDegree   = 16
+	btMaxItems = 2*btDegree - 1
+)
+
+// btree is an in-memory B-tree ordered by cmp, it backs SortedMap and SortedMapFunc.
+type btree[K, V any] struct {
+	root *btNode[K, V]
+	cmp  func(a, b K) int
+	ln   int
+}
+
+type btItem[K, V any] struct {
+	k K
+	v V
+}
+
+type btNode[K, V any] struct {
+	items    []btItem[K, V]
+	children []*btNode[K, V] // nil for leaves
+}
+
+func (n *btNode[K, V]) leaf() bool {
+	return len(n.children) == 0
+}
+
+// btRemoveAt removes s[i] and clears the freed slot so the gc can collect it.
+func btRemoveAt[E any](s []E, i int) []E {
+	s = Delete(s, i, i+1)
+	var zero E
+	s[:len(s)+1][len(s)] = zero
+	return s
+}
+
+// find returns the index of the first item of n >= k and whether it's equal to k.
+func (t *btree[K, V]) find(n *btNode[K, V], k K) (int, bool) {
+	i := Search(len(n.items), func(i int) bool { return t.cmp(n.items[i].k, k) >= 0 })
+	return i, i < len(n.items) && t.cmp(n.items[i].k, k) == 0
+}
+
+func (t *btree[K, V]) get(k K) *btItem[K, V] {
+	for n := t.root; n != nil; {
+		i, found := t.find(n, k)
+		if found {
+			return &n.items[i]
+		}
+		if n.leaf() {
+			return nil
+		}
+		n = n.children[i]
+	}
+	return nil
+}
+
+// set sets k to v and returns true if k is a new key, full nodes are split on the way down.
+func (t *btree[K, V]) set(k K, v V) bool {
+	if t.root == nil {
+		t.root = &btNode[K, V]{items: []btItem[K, V]{{k, v}}}
+		t.ln = 1
+		return true
+	}
+	if len(t.root.items) == btMaxItems {
+		t.root = &btNode[K, V]{children: []*btNode[K, V]{t.root}}
+		t.split(t.root, 0)
+	}
+	n := t.root
+	for {
+		i, found := t.find(n, k)
+		if found {
+			n.items[i].v = v
+			return false
+		}
+		if n.leaf() {
+			n.items = Insert(n.items, i, btItem[K, V]{k, v})
+			t.ln++
+			return true
+		}
+		if len(n.children[i].items) == btMaxItems {
+			t.split(n, i)
+			if c := t.cmp(k, n.items[i].k); c == 0 {
+				n.items[i].v = v
+				return false
+			} else if c > 0 {
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// split moves the median item of the full child i of n up to n and its right half to a new child.
+func (t *btree[K, V]) split(n *btNode[K, V], i int) {
+	const mid = btDegree - 1
+	c := n.children[i]
+	r := &btNode[K, V]{items: append(make([]btItem[K, V], 0, btMaxItems), c.items[mid+1:]...)}
+	if !c.leaf() {
+		r.children = append(make([]*btNode[K, V], 0, btMaxItems+1), c.children[mid+1:]...)
+		clear(c.children[mid+1:])
+		c.children = c.children[:mid+1]
+	}
+	it := c.items[mid]
+	clear(c.items[mid:])
+	c.items = c.items[:mid]
+	n.items = Insert(n.items, i, it)
+	n.children = Insert(n.children, i+1, r)
+}
+
+func (t *btree[K, V]) delete(k K) (v V, ok bool) {
+	if t.root == nil {
+		return
+	}
+	if v, ok = t.remove(t.root, k); ok {
+		t.ln--
+	}
+	if len(t.root.items) == 0 {
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	return
+}
+
+// remove removes k from the subtree of n, every child is filled to at least btDegree items before descending into it.
+func (t *btree[K, V]) remove(n *btNode[K, V], k K) (v V, ok bool) {
+	for {
+		i, found := t.find(n, k)
+		if n.leaf() {
+			if !found {
+				return v, false
+			}
+			v = n.items[i].v
+			n.items = btRemoveAt(n.items, i)
+			return v, true
+		}
+		if !found {
+			n = n.children[t.fill(n, i)]
+			continue
+		}
+		v = n.items[i].v
+		switch {
+		case len(n.children[i].items) >= btDegree:
+			n.items[i] = t.removeMax(n.children[i])
+			return v, true
+		case len(n.children[i+1].items) >= btDegree:
+			n.items[i] = t.removeMin(n.children[i+1])
+			return v, true
+		}
+		t.merge(n, i) // k moves down to the merged child
+		n = n.children[i]
+	}
+}
+
+func (t *btree[K, V]) removeMin(n *btNode[K, V]) (it btItem[K, V]) {
+	for !n.leaf() {
+		n = n.children[t.fill(n, 0)]
+	}
+	it = n.items[0]
+	n.items = btRemoveAt(n.items, 0)
+	return it
+}
+
+func (t *btree[K, V]) removeMax(n *btNode[K, V]) (it btItem[K, V]) {
+	for !n.leaf() {
+		n = n.children[t.fill(n, len(n.children)-1)]
+	}
+	it = n.items[len(n.items)-1]
+	n.items = btRemoveAt(n.items, len(n.items)-1)
+	return it
+}
+
+// fill makes sure child i of n has at least btDegree items by borrowing from or merging with a sibling,
+// it returns the new index of the child.
+func (t *btree[K, V]) fill(n *btNode[K, V], i int) int {
+	c := n.children[i]
+	if len(c.items) >= btDegree {
+		return i
+	}
+	if i > 0 && len(n.children[i-1].items) >= btDegree {
+		l := n.children[i-1]
+		c.items = Insert(c.items, 0, n.items[i-1])
+		n.items[i-1] = l.items[len(l.items)-1]
+		l.items = btRemoveAt(l.items, len(l.items)-1)
+		if !l.leaf() {
+			c.children = Insert(c.children, 0, l.children[len(l.children)-1])
+			l.children = btRemoveAt(l.children, len(l.children)-1)
+		}
+		return i
+	}
+	if i < len(n.children)-1 && len(n.children[i+1].items) >= btDegree {
+		r := n.children[i+1]
+		c.items = append(c.items, n.items[i])
+		n.items[i] = r.items[0]
+		r.items = btRemoveAt(r.items, 0)
+		if !r.leaf() {
+			c.children = append(c.children, r.children[0])
+			r.children = btRemoveAt(r.children, 0)
+		}
+		return i
+	}
+	if i == len(n.children)-1 {
+		i--
+	}
+	t.merge(n, i)
+	return i
+}
+
+// merge moves item i of n and all of child i+1 into child i.
+func (t *btree[K, V]) merge(n *btNode[K, V], i int) {
+	c, r := n.children[i], n.children[i+1]
+	c.items = append(append(c.items, n.items[i]), r.items...)
+	c.children = append(c.children, r.children...)
+	n.items = btRemoveAt(n.items, i)
+	n.children = btRemoveAt(n.children, i+1)
+}
+
+func (t *btree[K, V]) min() *btItem[K, V] {
+	n := t.root
+	if n == nil {
+		return nil
+	}
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return &n.items[0]
+}
+
+func (t *btree[K, V]) max() *btItem[K, V] {
+	n := t.root
+	if n == nil {
+		return nil
+	}
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return &n.items[len(n.items)-1]
+}
+
+// floor returns the item with the greatest key <= k.
+func (t *btree[K, V]) floor(k K) (it *btItem[K, V]) {
+	for n := t.root; n != nil; {
+		i, found := t.find(n, k)
+		if found {
+			return &n.items[i]
+		}
+		if i > 0 {
+			it = &n.items[i-1]
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	return it
+}
+
+// ceil returns the item with the smallest key >= k.
+func (t *btree[K, V]) ceil(k K) (it *btItem[K, V]) {
+	for n := t.root; n != nil; {
+		i, found := t.find(n, k)
+		if found {
+			return &n.items[i]
+		}
+		if i < len(n.items) {
+			it = &n.items[i]
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	return it
+}
+
+// ascend calls yield for every item >= from (if not nil) and < to (if not nil) in order, it returns false if the loop was stopped.
+func (t *btree[K, V]) ascend(n *btNode[K, V], from, to *K, yield func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	i := 0
+	if from != nil {
+		i, _ = t.find(n, *from)
+	}
+	for ; i < len(n.items); i++ {
+		if !n.leaf() && !t.ascend(n.children[i], from, to, yield) {
+			return false
+		}
+		from = nil // everything after the first child is >= from
+		it := &n.items[i]
+		if to != nil && t.cmp(it.k, *to) >= 0 {
+			return false
+		}
+		if !yield(it.k, it.v) {
+			return false
+		}
+	}
+	if !n.leaf() {
+		return t.ascend(n.children[len(n.items)], from, to, yield)
+	}
+	return true
+}
+
+// descend calls yield for every item in reverse order, it returns false if the loop was stopped.
+func (t *btree[K, V]) descend(n *btNode[K, V], yield func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	for i := len(n.items); i >= 0; i-- {
+		if !n.leaf() && !t.descend(n.children[i], yield) {
+			return false
+		}
+		if i > 0 && !yield(n.items[i-1].k, n.items[i-1].v) {
+			return false
+		}
+	}
+	return true
+}
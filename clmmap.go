@@ -0,0 +1,423 @@
+package genh
+
+import (
+	"encoding/json"
+	"iter"
+	"sync"
+)
+
+func NewCLMultiMap[K1, K2 comparable, V any](sz int) *CLMultiMap[K1, K2, V] {
+	return &CLMultiMap[K1, K2, V]{m: make(map[K1]*clmChild[K2, V], sz)}
+}
+
+// CLMultiMap is a multimap where every child map has its own lock, the parent lock only guards adding and removing children,
+// so operations on different K1s never block each other.
+// The lock order is always parent -> child, callbacks must not use the map.
+type CLMultiMap[K1, K2 comparable, V any] struct {
+	m   map[K1]*clmChild[K2, V]
+	mux sync.RWMutex
+}
+
+type clmChild[K comparable, V any] struct {
+	m    map[K]V
+	mux  sync.RWMutex
+	dead bool // set once the child is removed from the parent, anyone holding it must look it up again
+}
+
+type clmEntry[K1, K2 comparable, V any] struct {
+	k K1
+	c *clmChild[K2, V]
+}
+
+func (lm *CLMultiMap[K1, K2, V]) child(k1 K1) (c *clmChild[K2, V]) {
+	lm.mux.RLock()
+	c = lm.m[k1]
+	lm.mux.RUnlock()
+	return c
+}
+
+func (lm *CLMultiMap[K1, K2, V]) mustChild(k1 K1) *clmChild[K2, V] {
+	if c := lm.child(k1); c != nil {
+		return c
+	}
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	c := lm.m[k1]
+	if c == nil {
+		if lm.m == nil {
+			lm.m = make(map[K1]*clmChild[K2, V])
+		}
+		c = &clmChild[K2, V]{} // m is created by the first write, so Update can pass nil for a new child
+		lm.m[k1] = c
+	}
+	return c
+}
+
+func (lm *CLMultiMap[K1, K2, V]) children() []clmEntry[K1, K2, V] {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	out := make([]clmEntry[K1, K2, V], 0, len(lm.m))
+	for k, c := range lm.m {
+		out = append(out, clmEntry[K1, K2, V]{k, c})
+	}
+	return out
+}
+
+// write calls fn with the k1 child write-locked, the child is created if it doesn't exist and create is true.
+func (lm *CLMultiMap[K1, K2, V]) write(k1 K1, create bool, fn func(c *clmChild[K2, V])) {
+	for {
+		var c *clmChild[K2, V]
+		if create {
+			c = lm.mustChild(k1)
+		} else if c = lm.child(k1); c == nil {
+			return
+		}
+		c.mux.Lock()
+		if c.dead {
+			c.mux.Unlock()
+			continue
+		}
+		fn(c)
+		c.mux.Unlock()
+		return
+	}
+}
+
+// read calls fn with the k1 child map read-locked, or nil if it doesn't exist.
+func (lm *CLMultiMap[K1, K2, V]) read(k1 K1, fn func(m map[K2]V)) {
+	for {
+		c := lm.child(k1)
+		if c == nil {
+			fn(nil)
+			return
+		}
+		c.mux.RLock()
+		if c.dead {
+			c.mux.RUnlock()
+			continue
+		}
+		fn(c.m)
+		c.mux.RUnlock()
+		return
+	}
+}
+
+// kill marks c as removed, lm.mux must be write-locked.
+func (c *clmChild[K, V]) kill() (m map[K]V) {
+	c.mux.Lock()
+	c.dead, m = true, c.m
+	c.mux.Unlock()
+	return m
+}
+
+func (lm *CLMultiMap[K1, K2, V]) Set(k1 K1, k2 K2, v V) {
+	lm.write(k1, true, func(c *clmChild[K2, V]) {
+		if c.m == nil {
+			c.m = make(map[K2]V)
+		}
+		c.m[k2] = v
+	})
+}
+
+func (lm *CLMultiMap[K1, K2, V]) SetChild(k1 K1, v map[K2]V) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	if c := lm.m[k1]; c != nil {
+		c.kill()
+	}
+	if lm.m == nil {
+		lm.m = make(map[K1]*clmChild[K2, V])
+	}
+	lm.m[k1] = &clmChild[K2, V]{m: v}
+}
+
+func (lm *CLMultiMap[K1, K2, V]) SetMap(m map[K1]map[K2]V) (old map[K1]map[K2]V) {
+	nm := make(map[K1]*clmChild[K2, V], len(m))
+	for k, v := range m {
+		nm[k] = &clmChild[K2, V]{m: v}
+	}
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	old = make(map[K1]map[K2]V, len(lm.m))
+	for k, c := range lm.m {
+		old[k] = c.kill()
+	}
+	lm.m = nm
+	return old
+}
+
+// Update replaces the k1 child map with the one returned by fn while only the child is locked,
+// fn gets nil if k1 doesn't exist and the child is deleted if fn returns nil, the same as LMultiMap.Update.
+func (lm *CLMultiMap[K1, K2, V]) Update(k1 K1, fn func(m map[K2]V) map[K2]V) {
+	var (
+		c       *clmChild[K2, V]
+		deleted bool
+	)
+	lm.write(k1, true, func(wc *clmChild[K2, V]) {
+		c, wc.m = wc, fn(wc.m)
+		deleted = wc.m == nil
+	})
+	if !deleted {
+		return
+	}
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	if lm.m[k1] != c {
+		return
+	}
+	c.mux.Lock()
+	if c.m == nil { // nothing was set after fn returned
+		c.dead = true
+		delete(lm.m, k1)
+	}
+	c.mux.Unlock()
+}
+
+func (lm *CLMultiMap[K1, K2, V]) DeleteChild(k1 K1, k2 K2) {
+	lm.DeleteGetChild(k1, k2)
+}
+
+func (lm *CLMultiMap[K1, K2, V]) DeleteGetChild(k1 K1, k2 K2) (v V) {
+	lm.write(k1, false, func(c *clmChild[K2, V]) {
+		v = c.m[k2]
+		delete(c.m, k2)
+	})
+	return v
+}
+
+func (lm *CLMultiMap[K1, K2, V]) Delete(k1 K1) {
+	lm.DeleteGet(k1)
+}
+
+func (lm *CLMultiMap[K1, K2, V]) DeleteGet(k1 K1) (v map[K2]V) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	if c := lm.m[k1]; c != nil {
+		v = c.kill()
+		delete(lm.m, k1)
+	}
+	return v
+}
+
+func (lm *CLMultiMap[K1, K2, V]) Keys() (keys []K1) {
+	lm.mux.RLock()
+	keys = MapKeys(lm.m)
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) KeysChild(k1 K1) (keys []K2) {
+	lm.read(k1, func(m map[K2]V) { keys = MapKeys(m) })
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) Values(copy bool) (values []map[K2]V) {
+	cs := lm.children()
+	values = make([]map[K2]V, 0, len(cs))
+	for _, e := range cs {
+		e.c.mux.RLock()
+		if v := e.c.m; !e.c.dead {
+			if copy {
+				v = MapClone(v)
+			}
+			values = append(values, v)
+		}
+		e.c.mux.RUnlock()
+	}
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) ValuesChild(k1 K1) (values []V) {
+	lm.read(k1, func(m map[K2]V) { values = MapValues(m) })
+	return
+}
+
+// Clone returns a copy of the map, each child is copied separately so it's not a point-in-time copy.
+func (lm *CLMultiMap[K1, K2, V]) Clone() (m map[K1]map[K2]V) {
+	cs := lm.children()
+	m = make(map[K1]map[K2]V, len(cs))
+	for _, e := range cs {
+		e.c.mux.RLock()
+		if !e.c.dead {
+			m[e.k] = MapClone(e.c.m)
+		}
+		e.c.mux.RUnlock()
+	}
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) Get(k1 K1, k2 K2) (v V) {
+	v, _ = lm.GetOk(k1, k2)
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) GetOk(k1 K1, k2 K2) (v V, ok bool) {
+	lm.read(k1, func(m map[K2]V) { v, ok = m[k2] })
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) MustGet(k1 K1, k2 K2, fn func() V) V {
+	if v, ok := lm.GetOk(k1, k2); ok {
+		return v
+	}
+
+	var nv V
+	if fn != nil {
+		// create outside lock in case it's heavy, there's a chance it won't be used
+		nv = fn()
+	}
+
+	lm.write(k1, true, func(c *clmChild[K2, V]) {
+		if v, ok := c.m[k2]; ok { // race check
+			nv = v
+			return
+		}
+		if c.m == nil {
+			c.m = make(map[K2]V)
+		}
+		c.m[k2] = nv
+	})
+	return nv
+}
+
+func (lm *CLMultiMap[K1, K2, V]) ReadChild(k1 K1, fn func(m map[K2]V)) {
+	lm.read(k1, fn)
+}
+
+func (lm *CLMultiMap[K1, K2, V]) GetChild(k1 K1, copy bool) (m map[K2]V) {
+	lm.read(k1, func(cm map[K2]V) {
+		if m = cm; copy {
+			m = MapClone(cm)
+		}
+	})
+	return
+}
+
+// ForEachAll calls fn for every entry, only the child being iterated is read-locked.
+func (lm *CLMultiMap[K1, K2, V]) ForEachAll(fn func(k1 K1, k2 K2, v V) bool) {
+	for k1, m := range lm.All() {
+		for k2, v := range m {
+			if !fn(k1, k2, v) {
+				return
+			}
+		}
+	}
+}
+
+// ForEach calls fn for every child map, only the child being iterated is locked (write-locked if rw is true).
+func (lm *CLMultiMap[K1, K2, V]) ForEach(fn func(k1 K1, m map[K2]V) bool, rw bool) {
+	for _, e := range lm.children() {
+		var cont bool
+		if rw {
+			e.c.mux.Lock()
+			cont = e.c.dead || fn(e.k, e.c.m)
+			e.c.mux.Unlock()
+		} else {
+			e.c.mux.RLock()
+			cont = e.c.dead || fn(e.k, e.c.m)
+			e.c.mux.RUnlock()
+		}
+		if !cont {
+			return
+		}
+	}
+}
+
+func (lm *CLMultiMap[K1, K2, V]) ForEachChild(k1 K1, fn func(k2 K2, v V) bool) {
+	lm.read(k1, func(m map[K2]V) {
+		for k2, v := range m {
+			if !fn(k2, v) {
+				return
+			}
+		}
+	})
+}
+
+// KeysSeq returns an iterator over a snapshot of the top level keys, nothing is locked during the loop.
+func (lm *CLMultiMap[K1, K2, V]) KeysSeq() iter.Seq[K1] {
+	return func(yield func(K1) bool) {
+		for _, k := range lm.Keys() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the top level keys and copies of their child maps, nothing is locked during the loop.
+func (lm *CLMultiMap[K1, K2, V]) All() iter.Seq2[K1, map[K2]V] {
+	return func(yield func(K1, map[K2]V) bool) {
+		for _, e := range lm.children() {
+			e.c.mux.RLock()
+			m, dead := MapClone(e.c.m), e.c.dead
+			e.c.mux.RUnlock()
+			if !dead && !yield(e.k, m) {
+				return
+			}
+		}
+	}
+}
+
+// AllChild returns an iterator over the entries of the k1 child map, the child is read-locked until the loop is done.
+func (lm *CLMultiMap[K1, K2, V]) AllChild(k1 K1) iter.Seq2[K2, V] {
+	return func(yield func(K2, V) bool) {
+		lm.ForEachChild(k1, yield)
+	}
+}
+
+func (lm *CLMultiMap[K1, K2, V]) Clear() {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	for k, c := range lm.m {
+		c.kill()
+		delete(lm.m, k)
+	}
+}
+
+func (lm *CLMultiMap[K1, K2, V]) ClearChild(k1 K1) {
+	lm.write(k1, false, func(c *clmChild[K2, V]) { clear(c.m) })
+}
+
+func (lm *CLMultiMap[K1, K2, V]) Len() (v int) {
+	lm.mux.RLock()
+	v = len(lm.m)
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) LenChild(k1 K1) (v int) {
+	lm.read(k1, func(m map[K2]V) { v = len(m) })
+	return
+}
+
+func (lm *CLMultiMap[K1, K2, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lm.Clone())
+}
+
+// UnmarshalJSON replaces the child maps found in p, other children are kept.
+func (lm *CLMultiMap[K1, K2, V]) UnmarshalJSON(p []byte) error {
+	var m map[K1]map[K2]V
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		lm.SetChild(k, v)
+	}
+	return nil
+}
+
+func (lm *CLMultiMap[K1, K2, V]) MarshalBinary() ([]byte, error) {
+	return MarshalMsgpack(lm.Clone())
+}
+
+// UnmarshalBinary replaces the child maps found in p, other children are kept.
+func (lm *CLMultiMap[K1, K2, V]) UnmarshalBinary(p []byte) error {
+	var m map[K1]map[K2]V
+	if err := UnmarshalMsgpack(p, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		lm.SetChild(k, v)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package genh
+
+import (
+	"encoding/json"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+func CowMapOf[K comparable, V any](m map[K]V) *CowMap[K, V] {
+	var cm CowMap[K, V]
+	m = MapClone(m)
+	cm.p.Store(&m)
+	return &cm
+}
+
+// CowMap is a copy-on-write map, reads never lock and writes copy the whole map and swap it atomically.
+// It's meant for read-mostly maps that rarely change, use LMap or SLMap for anything else.
+// The zero value is ready to use.
+type CowMap[K comparable, V any] struct {
+	p   atomic.Pointer[map[K]V]
+	mux sync.Mutex // serializes writers
+}
+
+// load returns the current map, it must never be modified.
+func (cm *CowMap[K, V]) load() map[K]V {
+	if p := cm.p.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// write calls fn with a copy of the current map and stores it if fn returns true.
+func (cm *CowMap[K, V]) write(fn func(m map[K]V) bool) {
+	cm.mux.Lock()
+	defer cm.mux.Unlock()
+	m := cm.load()
+	nm := make(map[K]V, len(m)+1)
+	for k, v := range m {
+		nm[k] = v
+	}
+	if fn(nm) {
+		cm.p.Store(&nm)
+	}
+}
+
+func (cm *CowMap[K, V]) Set(k K, v V) {
+	cm.write(func(m map[K]V) bool {
+		m[k] = v
+		return true
+	})
+}
+
+func (cm *CowMap[K, V]) UpdateKey(k K, fn func(V) V) {
+	cm.write(func(m map[K]V) bool {
+		m[k] = fn(m[k])
+		return true
+	})
+}
+
+func (cm *CowMap[K, V]) Swap(k K, v V) (old V) {
+	cm.write(func(m map[K]V) bool {
+		old = m[k]
+		m[k] = v
+		return true
+	})
+	return old
+}
+
+func (cm *CowMap[K, V]) Delete(k K) {
+	cm.DeleteGet(k)
+}
+
+func (cm *CowMap[K, V]) DeleteGet(k K) (v V) {
+	cm.mux.Lock()
+	defer cm.mux.Unlock()
+	m := cm.load()
+	v, ok := m[k]
+	if !ok {
+		return v
+	}
+	nm := MapClone(m)
+	delete(nm, k)
+	cm.p.Store(&nm)
+	return v
+}
+
+// KeysSeq returns an iterator over the keys of the map at the time it was called.
+func (cm *CowMap[K, V]) KeysSeq() iter.Seq[K] {
+	m := cm.load()
+	return func(yield func(K) bool) {
+		for k := range m {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// ValuesSeq returns an iterator over the values of the map at the time it was called.
+func (cm *CowMap[K, V]) ValuesSeq() iter.Seq[V] {
+	m := cm.load()
+	return func(yield func(V) bool) {
+		for _, v := range m {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the entries of the map at the time it was called, it doesn't lock anything.
+func (cm *CowMap[K, V]) All() iter.Seq2[K, V] {
+	m := cm.load()
+	return func(yield func(K, V) bool) {
+		for k, v := range m {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (cm *CowMap[K, V]) Keys() []K {
+	return MapKeys(cm.load())
+}
+
+func (cm *CowMap[K, V]) Values() []V {
+	return MapValues(cm.load())
+}
+
+func (cm *CowMap[K, V]) Clone() map[K]V {
+	return MapClone(cm.load())
+}
+
+// Update calls fn with a copy of the map, which replaces the current map once fn returns.
+func (cm *CowMap[K, V]) Update(fn func(m map[K]V)) {
+	cm.write(func(m map[K]V) bool {
+		fn(m)
+		return true
+	})
+}
+
+// Read calls fn with the current map, which must not be modified.
+func (cm *CowMap[K, V]) Read(fn func(m map[K]V)) {
+	fn(cm.load())
+}
+
+func (cm *CowMap[K, V]) Get(k K) V {
+	return cm.load()[k]
+}
+
+func (cm *CowMap[K, V]) GetOk(k K) (v V, ok bool) {
+	v, ok = cm.load()[k]
+	return v, ok
+}
+
+// Compute calls fn with the current value of k while writers are locked out,
+// k is set to the returned value if keep is true, otherwise it's deleted.
+func (cm *CowMap[K, V]) Compute(k K, fn func(old V, exists bool) (nv V, keep bool)) (v V, ok bool) {
+	cm.write(func(m map[K]V) bool {
+		old, exists := m[k]
+		if v, ok = fn(old, exists); ok {
+			m[k] = v
+			return true
+		}
+		delete(m, k)
+		return exists
+	})
+	return v, ok
+}
+
+// LoadOrStore returns the existing value of k if it exists, otherwise it sets it to v and returns v.
+// loaded is true if the value was loaded, false if stored.
+func (cm *CowMap[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
+	if actual, loaded = cm.GetOk(k); loaded {
+		return
+	}
+	actual = v
+	cm.write(func(m map[K]V) bool {
+		var ov V
+		if ov, loaded = m[k]; loaded {
+			actual = ov
+			return false
+		}
+		m[k] = v
+		return true
+	})
+	return actual, loaded
+}
+
+// CompareAndSwap sets k to new if it exists and eq(current, old) returns true.
+func (cm *CowMap[K, V]) CompareAndSwap(k K, old, new V, eq func(a, b V) bool) (ok bool) {
+	if cur, exists := cm.GetOk(k); !exists || !eq(cur, old) {
+		return false
+	}
+	cm.write(func(m map[K]V) bool {
+		cur, exists := m[k]
+		if ok = exists && eq(cur, old); ok {
+			m[k] = new
+		}
+		return ok
+	})
+	return ok
+}
+
+func (cm *CowMap[K, V]) MustGet(k K, fn func() V) V {
+	if v, ok := cm.GetOk(k); ok {
+		return v
+	}
+	var nv V
+	if fn != nil {
+		// create outside lock in case it's heavy, there's a chance it won't be used
+		nv = fn()
+	}
+	v, _ := cm.LoadOrStore(k, nv)
+	return v
+}
+
+func (cm *CowMap[K, V]) ForEach(fn func(k K, v V) bool) {
+	for k, v := range cm.load() {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+func (cm *CowMap[K, V]) Clear() {
+	cm.mux.Lock()
+	cm.p.Store(nil)
+	cm.mux.Unlock()
+}
+
+// SetMap replaces the map with m, which must not be modified after that.
+func (cm *CowMap[K, V]) SetMap(m map[K]V) (old map[K]V) {
+	cm.mux.Lock()
+	old = cm.load()
+	cm.p.Store(&m)
+	cm.mux.Unlock()
+	return old
+}
+
+func (cm *CowMap[K, V]) Len() int {
+	return len(cm.load())
+}
+
+// Raw returns the current map, it must not be modified.
+func (cm *CowMap[K, V]) Raw() map[K]V {
+	return cm.load()
+}
+
+func (cm *CowMap[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cm.load())
+}
+
+func (cm *CowMap[K, V]) UnmarshalJSON(p []byte) error {
+	var nm map[K]V
+	if err := json.Unmarshal(p, &nm); err != nil {
+		return err
+	}
+	cm.Update(func(m map[K]V) { MapCopy(m, nm) })
+	return nil
+}
+
+func (cm *CowMap[K, V]) MarshalBinary() ([]byte, error) {
+	return MarshalMsgpack(cm.load())
+}
+
+func (cm *CowMap[K, V]) UnmarshalBinary(p []byte) error {
+	var nm map[K]V
+	if err := UnmarshalMsgpack(p, &nm); err != nil {
+		return err
+	}
+	cm.Update(func(m map[K]V) { MapCopy(m, nm) })
+	return nil
+}
@@ -0,0 +1,264 @@
+package genh
+
+import (
+	"encoding/json"
+	"iter"
+)
+
+// DElem is an element of a DList, it's a stable handle that stays valid until it's removed from its list.
+type DElem[T any] struct {
+	Value T
+
+	next, prev *DElem[T]
+	list       *DList[T]
+}
+
+// Next returns the next element or nil.
+func (e *DElem[T]) Next() *DElem[T] {
+	if n := e.next; e.list != nil && n != &e.list.root {
+		return n
+	}
+	return nil
+}
+
+// Prev returns the previous element or nil.
+func (e *DElem[T]) Prev() *DElem[T] {
+	if p := e.prev; e.list != nil && p != &e.list.root {
+		return p
+	}
+	return nil
+}
+
+func DListOf[T any](vs ...T) *DList[T] {
+	var l DList[T]
+	for _, v := range vs {
+		l.PushBack(v)
+	}
+	return &l
+}
+
+// DList is a doubly linked list with O(1) insertion, removal and moving of elements through their handles,
+// it can be used to back LRU caches and similar structures.
+// The zero value is ready to use, a DList must not be copied after its first use.
+type DList[T any] struct {
+	root DElem[T] // sentinel, root.next is the front and root.prev is the back
+	len  int
+}
+
+func (l *DList[T]) lazyInit() {
+	if l.root.next == nil {
+		l.root.next, l.root.prev = &l.root, &l.root
+	}
+}
+
+func (l *DList[T]) Len() int {
+	return l.len
+}
+
+// Front returns the first element or nil.
+func (l *DList[T]) Front() *DElem[T] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+// Back returns the last element or nil.
+func (l *DList[T]) Back() *DElem[T] {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+func (l *DList[T]) insert(e, at *DElem[T]) *DElem[T] {
+	e.prev, e.next = at, at.next
+	e.prev.next, e.next.prev = e, e
+	e.list = l
+	l.len++
+	return e
+}
+
+func (l *DList[T]) unlink(e *DElem[T]) {
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.next, e.prev, e.list = nil, nil, nil
+	l.len--
+}
+
+func (l *DList[T]) move(e, at *DElem[T]) {
+	if e == at || e == at.next {
+		return
+	}
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.prev, e.next = at, at.next
+	e.prev.next, e.next.prev = e, e
+}
+
+func (l *DList[T]) PushFront(v T) *DElem[T] {
+	l.lazyInit()
+	return l.insert(&DElem[T]{Value: v}, &l.root)
+}
+
+func (l *DList[T]) PushBack(v T) *DElem[T] {
+	l.lazyInit()
+	return l.insert(&DElem[T]{Value: v}, l.root.prev)
+}
+
+// InsertBefore inserts v before mark and returns its element, mark must be an element of l.
+func (l *DList[T]) InsertBefore(v T, mark *DElem[T]) *DElem[T] {
+	if mark.list != l {
+		panic("genh: mark isn't an element of the list")
+	}
+	return l.insert(&DElem[T]{Value: v}, mark.prev)
+}
+
+// InsertAfter inserts v after mark and returns its element, mark must be an element of l.
+func (l *DList[T]) InsertAfter(v T, mark *DElem[T]) *DElem[T] {
+	if mark.list != l {
+		panic("genh: mark isn't an element of the list")
+	}
+	return l.insert(&DElem[T]{Value: v}, mark)
+}
+
+// Remove removes e from l and returns its value, it returns false if e isn't an element of l.
+func (l *DList[T]) Remove(e *DElem[T]) (v T, ok bool) {
+	if e == nil || e.list != l {
+		return
+	}
+	l.unlink(e)
+	return e.Value, true
+}
+
+// PopFront removes and returns the first value.
+func (l *DList[T]) PopFront() (v T, ok bool) {
+	return l.Remove(l.Front())
+}
+
+// PopBack removes and returns the last value.
+func (l *DList[T]) PopBack() (v T, ok bool) {
+	return l.Remove(l.Back())
+}
+
+// MoveToFront moves e to the front of l, it's a no-op if e isn't an element of l.
+func (l *DList[T]) MoveToFront(e *DElem[T]) {
+	if e.list == l {
+		l.move(e, &l.root)
+	}
+}
+
+// MoveToBack moves e to the back of l, it's a no-op if e isn't an element of l.
+func (l *DList[T]) MoveToBack(e *DElem[T]) {
+	if e.list == l {
+		l.move(e, l.root.prev)
+	}
+}
+
+// MoveBefore moves e before mark, it's a no-op if either isn't an element of l.
+func (l *DList[T]) MoveBefore(e, mark *DElem[T]) {
+	if e.list == l && mark.list == l {
+		l.move(e, mark.prev)
+	}
+}
+
+// MoveAfter moves e after mark, it's a no-op if either isn't an element of l.
+func (l *DList[T]) MoveAfter(e, mark *DElem[T]) {
+	if e.list == l && mark.list == l {
+		l.move(e, mark)
+	}
+}
+
+// Clear removes all the elements, their handles become invalid.
+func (l *DList[T]) Clear() {
+	for e := l.Front(); e != nil; {
+		next := e.Next()
+		e.next, e.prev, e.list = nil, nil, nil
+		e = next
+	}
+	l.root.next, l.root.prev, l.len = &l.root, &l.root, 0
+}
+
+// Elems returns an iterator over the elements from front to back,
+// the current element can be removed or moved during the loop.
+func (l *DList[T]) Elems() iter.Seq[*DElem[T]] {
+	return func(yield func(*DElem[T]) bool) {
+		for e := l.Front(); e != nil; {
+			next := e.Next()
+			if !yield(e) {
+				return
+			}
+			e = next
+		}
+	}
+}
+
+// Values returns an iterator over the values from front to back,
+// the current element can be removed or moved during the loop.
+func (l *DList[T]) Values() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for e := range l.Elems() {
+			if !yield(e.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the values from back to front,
+// the current element can be removed or moved during the loop.
+func (l *DList[T]) Backward() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for e := l.Back(); e != nil; {
+			prev := e.Prev()
+			if !yield(e.Value) {
+				return
+			}
+			e = prev
+		}
+	}
+}
+
+func (l *DList[T]) Slice() (out []T) {
+	if l.len == 0 {
+		return
+	}
+	out = make([]T, 0, l.len)
+	for v := range l.Values() {
+		out = append(out, v)
+	}
+	return
+}
+
+// MarshalJSON encodes the list as a json array, the same as List.
+func (l *DList[T]) MarshalJSON() ([]byte, error) {
+	if l.len == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(l.Slice())
+}
+
+// UnmarshalJSON appends the values of a json array.
+func (l *DList[T]) UnmarshalJSON(p []byte) error {
+	var vs []T
+	if err := json.Unmarshal(p, &vs); err != nil {
+		return err
+	}
+	for _, v := range vs {
+		l.PushBack(v)
+	}
+	return nil
+}
+
+func (l *DList[T]) MarshalBinary() ([]byte, error) {
+	return MarshalMsgpack(l.Slice())
+}
+
+func (l *DList[T]) UnmarshalBinary(p []byte) error {
+	var vs []T
+	if err := UnmarshalMsgpack(p, &vs); err != nil {
+		return err
+	}
+	for _, v := range vs {
+		l.PushBack(v)
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package gsets
 
 type (
-	Strings     = Set[string]
-	SafeStrings = SafeSet[string]
-	Ints        = Set[int]
-	SafeInts    = SafeSet[int]
-	Int64s      = Set[int64]
-	SafeInt64s  = SafeSet[int64]
+	Strings        = Set[string]
+	SafeStrings    = SafeSet[string]
+	ShardedStrings = ShardedSet[string]
+	Ints           = Set[int]
+	SafeInts       = SafeSet[int]
+	ShardedInts    = ShardedSet[int]
+	Int64s         = Set[int64]
+	SafeInt64s     = SafeSet[int64]
+	ShardedInt64s  = ShardedSet[int64]
 )
@@ -0,0 +1,338 @@
+package gsets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"math/bits"
+	"strconv"
+
+	"go.oneofone.dev/genh/internal"
+)
+
+// BitSetMaxKey is the largest key a BitSet accepts, a BitSet holding it uses 2MiB.
+const BitSetMaxKey = 1<<24 - 1
+
+var ErrBitSetKeyTooLarge = errors.New("gsets: BitSet key too large")
+
+func BitSetOf[T internal.Unsigned](keys ...T) *BitSet[T] {
+	var bs BitSet[T]
+	return bs.Add(keys...)
+}
+
+// BitSetFromSet returns a BitSet with the keys of s, it panics if a key is larger than BitSetMaxKey.
+func BitSetFromSet[T internal.Unsigned](s Set[T]) *BitSet[T] {
+	var bs BitSet[T]
+	for k := range s {
+		bs.Add(k)
+	}
+	return &bs
+}
+
+// BitSet is a set of unsigned integers stored as a bitmap that grows on demand,
+// it uses 1 bit per possible value up to the largest key, so it's meant for dense ranges of small integers,
+// keys are limited to BitSetMaxKey, use Set for sparse or large keys.
+// It uses the same json and binary encodings as Set, the zero value is ready to use.
+type BitSet[T internal.Unsigned] struct {
+	w []uint64
+}
+
+func bitPos[T internal.Unsigned](k T) (int, uint64) {
+	return int(k / 64), 1 << (k % 64)
+}
+
+// trim removes the trailing empty words.
+func (bs *BitSet[T]) trim() {
+	i := len(bs.w)
+	for i > 0 && bs.w[i-1] == 0 {
+		i--
+	}
+	bs.w = bs.w[:i]
+}
+
+// Add adds keys to the set, it panics if a key is larger than BitSetMaxKey.
+func (bs *BitSet[T]) Add(keys ...T) *BitSet[T] {
+	for _, k := range keys {
+		if uint64(k) > BitSetMaxKey {
+			panic(fmt.Sprintf("%v: %d", ErrBitSetKeyTooLarge, uint64(k)))
+		}
+		i, b := bitPos(k)
+		if i >= len(bs.w) {
+			bs.w = append(bs.w, make([]uint64, i+1-len(bs.w))...)
+		}
+		bs.w[i] |= b
+	}
+	return bs
+}
+
+// AddIfNotExists returns true if the key was added, false if it already existed
+func (bs *BitSet[T]) AddIfNotExists(key T) bool {
+	if bs.Has(key) {
+		return false
+	}
+	bs.Add(key)
+	return true
+}
+
+func (bs *BitSet[T]) Delete(keys ...T) *BitSet[T] {
+	for _, k := range keys {
+		if uint64(k) > BitSetMaxKey {
+			continue
+		}
+		if i, b := bitPos(k); i < len(bs.w) {
+			bs.w[i] &^= b
+		}
+	}
+	bs.trim()
+	return bs
+}
+
+func (bs *BitSet[T]) Has(key T) bool {
+	if uint64(key) > BitSetMaxKey {
+		return false
+	}
+	i, b := bitPos(key)
+	return i < len(bs.w) && bs.w[i]&b != 0
+}
+
+// Len returns the number of keys in the set.
+func (bs *BitSet[T]) Len() (n int) {
+	for _, w := range bs.w {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+func (bs *BitSet[T]) Clear() {
+	clear(bs.w)
+	bs.w = bs.w[:0]
+}
+
+func (bs *BitSet[T]) Clone() *BitSet[T] {
+	return &BitSet[T]{w: append([]uint64(nil), bs.w...)}
+}
+
+func (bs *BitSet[T]) Equal(o *BitSet[T]) bool {
+	a, b := bs.w, o.w
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	for i, w := range a {
+		if w != b[i] {
+			return false
+		}
+	}
+	for _, w := range b[len(a):] {
+		if w != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge adds all the keys of os to bs.
+func (bs *BitSet[T]) Merge(os ...*BitSet[T]) *BitSet[T] {
+	for _, o := range os {
+		if len(o.w) > len(bs.w) {
+			bs.w = append(bs.w, make([]uint64, len(o.w)-len(bs.w))...)
+		}
+		for i, w := range o.w {
+			bs.w[i] |= w
+		}
+	}
+	return bs
+}
+
+// IntersectWith removes all the keys of bs that aren't in every one of os.
+func (bs *BitSet[T]) IntersectWith(os ...*BitSet[T]) *BitSet[T] {
+	for _, o := range os {
+		if len(o.w) < len(bs.w) {
+			clear(bs.w[len(o.w):])
+			bs.w = bs.w[:len(o.w)]
+		}
+		for i := range bs.w {
+			bs.w[i] &= o.w[i]
+		}
+	}
+	bs.trim()
+	return bs
+}
+
+// DifferenceWith removes all the keys of os from bs.
+func (bs *BitSet[T]) DifferenceWith(os ...*BitSet[T]) *BitSet[T] {
+	for _, o := range os {
+		for i := range min(len(bs.w), len(o.w)) {
+			bs.w[i] &^= o.w[i]
+		}
+	}
+	bs.trim()
+	return bs
+}
+
+// SymmetricDifferenceWith toggles every key of os in bs.
+func (bs *BitSet[T]) SymmetricDifferenceWith(os ...*BitSet[T]) *BitSet[T] {
+	for _, o := range os {
+		if len(o.w) > len(bs.w) {
+			bs.w = append(bs.w, make([]uint64, len(o.w)-len(bs.w))...)
+		}
+		for i, w := range o.w {
+			bs.w[i] ^= w
+		}
+	}
+	bs.trim()
+	return bs
+}
+
+// Union returns a new BitSet with the keys of bs and all of os.
+func (bs *BitSet[T]) Union(os ...*BitSet[T]) *BitSet[T] {
+	return bs.Clone().Merge(os...)
+}
+
+// Intersect returns a new BitSet with the keys that are in bs and all of os.
+func (bs *BitSet[T]) Intersect(os ...*BitSet[T]) *BitSet[T] {
+	return bs.Clone().IntersectWith(os...)
+}
+
+// Difference returns a new BitSet with the keys of bs that aren't in any of os.
+func (bs *BitSet[T]) Difference(os ...*BitSet[T]) *BitSet[T] {
+	return bs.Clone().DifferenceWith(os...)
+}
+
+// SymmetricDifference returns a new BitSet with the keys that are in an odd number of bs and os.
+func (bs *BitSet[T]) SymmetricDifference(os ...*BitSet[T]) *BitSet[T] {
+	return bs.Clone().SymmetricDifferenceWith(os...)
+}
+
+// IsSubset returns true if every key of bs is in o.
+func (bs *BitSet[T]) IsSubset(o *BitSet[T]) bool {
+	for i, w := range bs.w {
+		var ow uint64
+		if i < len(o.w) {
+			ow = o.w[i]
+		}
+		if w&^ow != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSuperset returns true if every key of o is in bs.
+func (bs *BitSet[T]) IsSuperset(o *BitSet[T]) bool {
+	return o.IsSubset(bs)
+}
+
+// Disjoint returns true if bs and o have no keys in common.
+func (bs *BitSet[T]) Disjoint(o *BitSet[T]) bool {
+	for i := range min(len(bs.w), len(o.w)) {
+		if bs.w[i]&o.w[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns the keys in ascending order.
+func (bs *BitSet[T]) Keys() []T {
+	n := bs.Len()
+	if n == 0 {
+		return nil
+	}
+	keys := make([]T, 0, n)
+	for k := range bs.Seq() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// SortedKeys is an alias for Keys, which is always sorted.
+func (bs *BitSet[T]) SortedKeys() []T {
+	return bs.Keys()
+}
+
+// Seq returns an iterator over the keys in ascending order, the set must not be modified during the loop.
+func (bs *BitSet[T]) Seq() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for i, w := range bs.w {
+			for w != 0 {
+				b := bits.TrailingZeros64(w)
+				if !yield(T(i*64 + b)) {
+					return
+				}
+				w &= w - 1
+			}
+		}
+	}
+}
+
+// ToSet returns a Set with the keys of bs.
+func (bs *BitSet[T]) ToSet() Set[T] {
+	s := make(Set[T], bs.Len())
+	for k := range bs.Seq() {
+		s[k] = struct{}{}
+	}
+	return s
+}
+
+func (bs *BitSet[T]) String() string {
+	b, _ := bs.MarshalJSON()
+	return string(b)
+}
+
+// MarshalJSON encodes the set as a sorted json array, the same as Set.MarshalJSON.
+func (bs *BitSet[T]) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	var tmp [20]byte
+	for k := range bs.Seq() {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(strconv.AppendUint(tmp[:0], uint64(k), 10))
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON adds the keys of a json array, it returns an error wrapping ErrBitSetKeyTooLarge
+// without modifying the set if any key is larger than BitSetMaxKey.
+func (bs *BitSet[T]) UnmarshalJSON(data []byte) error {
+	var keys []T
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	if err := checkBitSetKeys(keys); err != nil {
+		return err
+	}
+	bs.Add(keys...)
+	return nil
+}
+
+func (bs *BitSet[T]) MarshalBinary() ([]byte, error) {
+	return internal.MarshalMsgpack(bs.Keys())
+}
+
+// UnmarshalBinary replaces the keys of the set, see UnmarshalJSON for the key limit.
+func (bs *BitSet[T]) UnmarshalBinary(data []byte) error {
+	var keys []T
+	if err := internal.UnmarshalMsgpack(data, &keys); err != nil {
+		return err
+	}
+	if err := checkBitSetKeys(keys); err != nil {
+		return err
+	}
+	bs.Clear()
+	bs.Add(keys...)
+	return nil
+}
+
+func checkBitSetKeys[T internal.Unsigned](keys []T) error {
+	for _, k := range keys {
+		if uint64(k) > BitSetMaxKey {
+			return fmt.Errorf("%w: %d", ErrBitSetKeyTooLarge, uint64(k))
+		}
+	}
+	return nil
+}
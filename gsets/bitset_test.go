@@ -0,0 +1,107 @@
+package gsets
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"testing/quick"
+)
+
+func TestBitSet(t *testing.T) {
+	prop := func(a, b []uint16) bool {
+		// keep the bitmaps small
+		for i := range a {
+			a[i] %= 1000
+		}
+		for i := range b {
+			b[i] %= 300
+		}
+		ba, bb, sa, sb := BitSetOf(a...), BitSetOf(b...), Of(a...), Of(b...)
+		return ba.Len() == sa.Len() && ba.ToSet().Equal(sa) && BitSetFromSet(sa).Equal(ba) &&
+			slices.Equal(ba.Keys(), sa.SortedKeys()) &&
+			ba.Union(bb).ToSet().Equal(sa.Union(sb)) &&
+			ba.Intersect(bb).ToSet().Equal(sa.Intersect(sb)) &&
+			bb.Intersect(ba).ToSet().Equal(sa.Intersect(sb)) &&
+			ba.Difference(bb).ToSet().Equal(sa.Difference(sb)) &&
+			bb.Difference(ba).ToSet().Equal(sb.Difference(sa)) &&
+			ba.SymmetricDifference(bb).ToSet().Equal(sa.SymmetricDifference(sb)) &&
+			ba.IsSubset(bb) == sa.IsSubset(sb) && ba.IsSuperset(bb) == sa.IsSuperset(sb) &&
+			ba.Disjoint(bb) == sa.Disjoint(sb) &&
+			ba.Equal(BitSetOf(a...)) && ba.Intersect(bb).Union(ba.Difference(bb)).Equal(ba)
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var bs BitSet[uint8]
+	if !bs.AddIfNotExists(200) || bs.AddIfNotExists(200) || !bs.Has(200) || bs.Has(1) {
+		t.Fatal("unexpected", bs.Keys())
+	}
+	bs.Add(3, 64, 0)
+	bs.Delete(200)
+	if !bs.Equal(BitSetOf[uint8](0, 3, 64)) || len(bs.w) != 2 {
+		t.Fatal("unexpected", bs.Keys(), len(bs.w))
+	}
+	for k := range bs.Seq() {
+		if k != 0 {
+			t.Fatal("expected the loop to stop", k)
+		}
+		break
+	}
+
+	s := Of[uint8](64, 3, 0)
+	j, err := json.Marshal(&bs)
+	if sj, _ := json.Marshal(s); err != nil || string(j) != string(sj) || bs.String() != s.String() {
+		t.Fatal(err, string(j), string(sj))
+	}
+	var js Set[uint8]
+	if err = json.Unmarshal(j, &js); err != nil || !js.Equal(s) {
+		t.Fatal(err, js)
+	}
+	b, err := bs.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bsb BitSet[uint8]
+	if err = bsb.UnmarshalBinary(b); err != nil || !bsb.Equal(&bs) {
+		t.Fatal(err, bsb.Keys())
+	}
+	if b, err = s.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if err = bsb.UnmarshalBinary(b); err != nil || !bsb.Equal(&bs) {
+		t.Fatal(err, bsb.Keys())
+	}
+}
+
+func TestBitSetMaxKey(t *testing.T) {
+	bs := BitSetOf[uint64](1, 2)
+	for _, in := range []string{"[1,18446744073709551615]", "[3,16777216]"} {
+		if err := json.Unmarshal([]byte(in), bs); !errors.Is(err, ErrBitSetKeyTooLarge) {
+			t.Fatal("expected ErrBitSetKeyTooLarge, got", err)
+		}
+		if !slices.Equal(bs.Keys(), []uint64{1, 2}) {
+			t.Fatal("the set was modified", bs.Keys())
+		}
+	}
+	b, err := Of[uint64](5, 1<<40).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bs.UnmarshalBinary(b); !errors.Is(err, ErrBitSetKeyTooLarge) || bs.Len() != 2 {
+		t.Fatal("expected ErrBitSetKeyTooLarge, got", err, bs.Keys())
+	}
+	if bs.Has(1<<63) || bs.Delete(1<<63).Len() != 2 {
+		t.Fatal("unexpected", bs.Keys())
+	}
+	if err = json.Unmarshal([]byte("[16777215]"), bs); err != nil || !bs.Has(BitSetMaxKey) {
+		t.Fatal(err, bs.Len())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Add to panic")
+		}
+	}()
+	bs.Add(BitSetMaxKey + 1)
+}
@@ -0,0 +1,432 @@
+package gsets
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"iter"
+	"slices"
+	"sync"
+
+	"go.oneofone.dev/genh/internal"
+)
+
+// CounterEntry is a key and its count, it's used by MostCommon and the Counter encodings.
+type CounterEntry[T comparable] struct {
+	Key   T   `json:"key"`
+	Count int `json:"count"`
+}
+
+func CounterOf[T comparable](keys ...T) Counter[T] {
+	c := Counter[T]{}
+	c.Inc(keys...)
+	return c
+}
+
+// Counter is a multiset that counts the occurrences of its keys, keys with a count <= 0 are removed.
+type Counter[T comparable] map[T]int
+
+func (c *Counter[T]) init() Counter[T] {
+	if *c == nil {
+		*c = Counter[T]{}
+	}
+	return *c
+}
+
+// Add adds n to the count of k and returns the new count, a negative n works like Remove.
+func (c *Counter[T]) Add(k T, n int) int {
+	m := c.init()
+	n += m[k]
+	if n <= 0 {
+		delete(m, k)
+		return 0
+	}
+	m[k] = n
+	return n
+}
+
+// Inc adds 1 to the count of every key.
+func (c *Counter[T]) Inc(keys ...T) Counter[T] {
+	m := c.init()
+	for _, k := range keys {
+		m[k]++
+	}
+	return m
+}
+
+// Remove subtracts n from the count of k and returns the new count, k is removed once it reaches 0.
+func (c Counter[T]) Remove(k T, n int) int {
+	if c == nil {
+		return 0
+	}
+	return c.Add(k, -n)
+}
+
+// Delete removes keys regardless of their count.
+func (c Counter[T]) Delete(keys ...T) Counter[T] {
+	for _, k := range keys {
+		delete(c, k)
+	}
+	return c
+}
+
+func (c Counter[T]) Count(k T) int {
+	return c[k]
+}
+
+func (c Counter[T]) Has(k T) bool {
+	_, ok := c[k]
+	return ok
+}
+
+// Len returns the number of unique keys.
+func (c Counter[T]) Len() int {
+	return len(c)
+}
+
+// Total returns the sum of all the counts.
+func (c Counter[T]) Total() (n int) {
+	for _, v := range c {
+		n += v
+	}
+	return n
+}
+
+func (c Counter[T]) Clone() Counter[T] {
+	nc := make(Counter[T], len(c))
+	for k, v := range c {
+		nc[k] = v
+	}
+	return nc
+}
+
+func (c Counter[T]) Equal(o Counter[T]) bool {
+	if len(c) != len(o) {
+		return false
+	}
+	for k, v := range c {
+		if ov, ok := o[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Counter[T]) Keys() []T {
+	if c == nil {
+		return nil
+	}
+	keys := make([]T, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ToSet returns a Set of the keys.
+func (c Counter[T]) ToSet() Set[T] {
+	s := make(Set[T], len(c))
+	for k := range c {
+		s[k] = struct{}{}
+	}
+	return s
+}
+
+// All returns an iterator over the keys and their counts, the counter must not be modified during the loop.
+func (c Counter[T]) All() iter.Seq2[T, int] {
+	return func(yield func(T, int) bool) {
+		for k, v := range c {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// MostCommon returns the n keys with the highest counts, or all of them if n <= 0.
+// Ties are ordered by key for integer, float and string types.
+func (c Counter[T]) MostCommon(n int) []CounterEntry[T] {
+	if n <= 0 || n > len(c) {
+		n = len(c)
+	}
+	keyCmp := compareFunc[T]()
+	less := func(a, b CounterEntry[T]) int { // a before b
+		if r := cmp.Compare(b.Count, a.Count); r != 0 || keyCmp == nil {
+			return r
+		}
+		return keyCmp(a.Key, b.Key)
+	}
+
+	if n == len(c) {
+		es := make([]CounterEntry[T], 0, n)
+		for k, v := range c {
+			es = append(es, CounterEntry[T]{k, v})
+		}
+		slices.SortFunc(es, less)
+		return es
+	}
+
+	// keep the top n in a min-heap (worst entry first) while scanning, so it costs O(len(c) * log(n)).
+	top := &entryHeap[T]{less: less, es: make([]CounterEntry[T], 0, n)}
+	for k, v := range c {
+		e := CounterEntry[T]{k, v}
+		if len(top.es) < n {
+			heap.Push(top, e)
+		} else if less(e, top.es[0]) < 0 {
+			top.es[0] = e
+			heap.Fix(top, 0)
+		}
+	}
+	slices.SortFunc(top.es, less)
+	return top.es
+}
+
+// entryHeap is a container/heap of entries where the entry that sorts last according to less is on top.
+type entryHeap[T comparable] struct {
+	less func(a, b CounterEntry[T]) int
+	es   []CounterEntry[T]
+}
+
+func (h *entryHeap[T]) Len() int           { return len(h.es) }
+func (h *entryHeap[T]) Less(i, j int) bool { return h.less(h.es[i], h.es[j]) > 0 }
+func (h *entryHeap[T]) Swap(i, j int)      { h.es[i], h.es[j] = h.es[j], h.es[i] }
+func (h *entryHeap[T]) Push(x any)         { h.es = append(h.es, x.(CounterEntry[T])) }
+
+func (h *entryHeap[T]) Pop() any {
+	e := h.es[len(h.es)-1]
+	h.es = h.es[:len(h.es)-1]
+	return e
+}
+
+// Merge adds the counts of os to c (multiset sum).
+func (c *Counter[T]) Merge(os ...Counter[T]) Counter[T] {
+	m := c.init()
+	for _, o := range os {
+		for k, v := range o {
+			m[k] += v
+		}
+	}
+	return m
+}
+
+// UnionWith sets the count of every key to its max count in c and os.
+func (c *Counter[T]) UnionWith(os ...Counter[T]) Counter[T] {
+	m := c.init()
+	for _, o := range os {
+		for k, v := range o {
+			m[k] = max(m[k], v)
+		}
+	}
+	return m
+}
+
+// IntersectWith sets the count of every key to its min count in c and os, keys missing from any of os are removed.
+func (c Counter[T]) IntersectWith(os ...Counter[T]) Counter[T] {
+	for k, v := range c {
+		for _, o := range os {
+			if v = min(v, o[k]); v == 0 {
+				break
+			}
+		}
+		if v == 0 {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+// Union returns a new Counter with the max count of every key in c and os.
+func (c Counter[T]) Union(os ...Counter[T]) Counter[T] {
+	nc := c.Clone()
+	return nc.UnionWith(os...)
+}
+
+// Intersect returns a new Counter with the min count of every key in c and os.
+func (c Counter[T]) Intersect(os ...Counter[T]) Counter[T] {
+	return c.Clone().IntersectWith(os...)
+}
+
+// entries returns the entries sorted by key, in the same order Set.MarshalJSON uses.
+func (c Counter[T]) entries() []CounterEntry[T] {
+	es := make([]CounterEntry[T], 0, len(c))
+	for k, v := range c {
+		es = append(es, CounterEntry[T]{k, v})
+	}
+	if keyCmp := compareFunc[T](); keyCmp != nil {
+		slices.SortFunc(es, func(a, b CounterEntry[T]) int { return keyCmp(a.Key, b.Key) })
+	}
+	return es
+}
+
+// MarshalJSON encodes the counter as a json array of {"key", "count"} objects sorted by key,
+// types without a natural order are sorted by their json encoding.
+func (c Counter[T]) MarshalJSON() ([]byte, error) {
+	if len(c) > 0 && compareFunc[T]() == nil {
+		return marshalKeys(c.entries())
+	}
+	return json.Marshal(c.entries())
+}
+
+// UnmarshalJSON adds the counts to c.
+func (c *Counter[T]) UnmarshalJSON(data []byte) (err error) {
+	var es []CounterEntry[T]
+	if err = json.Unmarshal(data, &es); err == nil {
+		c.addEntries(es)
+	}
+	return err
+}
+
+func (c Counter[T]) MarshalBinary() ([]byte, error) {
+	return internal.MarshalMsgpack(c.entries())
+}
+
+func (c *Counter[T]) UnmarshalBinary(data []byte) (err error) {
+	var es []CounterEntry[T]
+	if err = internal.UnmarshalMsgpack(data, &es); err == nil {
+		*c = nil
+		c.addEntries(es)
+	}
+	return err
+}
+
+func (c *Counter[T]) addEntries(es []CounterEntry[T]) {
+	c.init()
+	for _, e := range es {
+		c.Add(e.Key, e.Count)
+	}
+}
+
+// LCounter is a locked Counter, the zero value is ready to use.
+type LCounter[T comparable] struct {
+	mux sync.RWMutex
+	c   Counter[T]
+}
+
+// Add adds n to the count of k and returns the new count.
+func (lc *LCounter[T]) Add(k T, n int) int {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	return lc.c.Add(k, n)
+}
+
+// Inc adds 1 to the count of every key.
+func (lc *LCounter[T]) Inc(keys ...T) {
+	lc.mux.Lock()
+	lc.c.Inc(keys...)
+	lc.mux.Unlock()
+}
+
+// Remove subtracts n from the count of k and returns the new count.
+func (lc *LCounter[T]) Remove(k T, n int) int {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	return lc.c.Remove(k, n)
+}
+
+func (lc *LCounter[T]) Delete(keys ...T) {
+	lc.mux.Lock()
+	lc.c.Delete(keys...)
+	lc.mux.Unlock()
+}
+
+func (lc *LCounter[T]) Count(k T) int {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c[k]
+}
+
+func (lc *LCounter[T]) Has(k T) bool {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c.Has(k)
+}
+
+func (lc *LCounter[T]) Len() int {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return len(lc.c)
+}
+
+func (lc *LCounter[T]) Total() int {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c.Total()
+}
+
+func (lc *LCounter[T]) Keys() []T {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c.Keys()
+}
+
+func (lc *LCounter[T]) MostCommon(n int) []CounterEntry[T] {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c.MostCommon(n)
+}
+
+func (lc *LCounter[T]) Merge(os ...Counter[T]) {
+	lc.mux.Lock()
+	lc.c.Merge(os...)
+	lc.mux.Unlock()
+}
+
+func (lc *LCounter[T]) UnionWith(os ...Counter[T]) {
+	lc.mux.Lock()
+	lc.c.UnionWith(os...)
+	lc.mux.Unlock()
+}
+
+func (lc *LCounter[T]) IntersectWith(os ...Counter[T]) {
+	lc.mux.Lock()
+	lc.c.IntersectWith(os...)
+	lc.mux.Unlock()
+}
+
+func (lc *LCounter[T]) Clear() {
+	lc.mux.Lock()
+	clear(lc.c)
+	lc.mux.Unlock()
+}
+
+// All returns an iterator over the keys and their counts, the counter is read-locked until the loop is done.
+func (lc *LCounter[T]) All() iter.Seq2[T, int] {
+	return func(yield func(T, int) bool) {
+		lc.mux.RLock()
+		defer lc.mux.RUnlock()
+		lc.c.All()(yield)
+	}
+}
+
+// Snapshot returns a copy of the underlying Counter.
+func (lc *LCounter[T]) Snapshot() Counter[T] {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c.Clone()
+}
+
+func (lc *LCounter[T]) MarshalJSON() ([]byte, error) {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c.MarshalJSON()
+}
+
+func (lc *LCounter[T]) UnmarshalJSON(data []byte) error {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	return lc.c.UnmarshalJSON(data)
+}
+
+func (lc *LCounter[T]) MarshalBinary() ([]byte, error) {
+	lc.mux.RLock()
+	defer lc.mux.RUnlock()
+	return lc.c.MarshalBinary()
+}
+
+func (lc *LCounter[T]) UnmarshalBinary(data []byte) error {
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	return lc.c.UnmarshalBinary(data)
+}
@@ -0,0 +1,85 @@
+package gsets
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+func TestCounter(t *testing.T) {
+	var c Counter[string]
+	c.Inc("a", "b", "a", "c", "a", "b")
+	if c.Add("d", 2) != 2 || c.Remove("c", 5) != 0 || c.Has("c") || c.Count("a") != 3 || c.Total() != 7 {
+		t.Fatal("unexpected", c)
+	}
+	exp := []CounterEntry[string]{{"a", 3}, {"b", 2}, {"d", 2}}
+	if top := c.MostCommon(0); !slices.Equal(top, exp) {
+		t.Fatal("unexpected", top)
+	}
+	if top := c.MostCommon(2); !slices.Equal(top, exp[:2]) {
+		t.Fatal("unexpected", top)
+	}
+
+	o := CounterOf("a", "b", "b", "b", "e")
+	if u := c.Union(o); !u.Equal(Counter[string]{"a": 3, "b": 3, "d": 2, "e": 1}) {
+		t.Fatal("unexpected union", u)
+	}
+	if i := c.Intersect(o); !i.Equal(Counter[string]{"a": 1, "b": 2}) {
+		t.Fatal("unexpected intersect", i)
+	}
+	if c.Count("a") != 3 || o.Count("b") != 3 {
+		t.Fatal("allocating ops modified their operands")
+	}
+	if m := c.Clone(); !m.Merge(o).Equal(Counter[string]{"a": 4, "b": 5, "d": 2, "e": 1}) {
+		t.Fatal("unexpected merge", m)
+	}
+
+	j, err := json.Marshal(c)
+	if err != nil || string(j) != `[{"key":"a","count":3},{"key":"b","count":2},{"key":"d","count":2}]` {
+		t.Fatal(err, string(j))
+	}
+	var lc LCounter[string]
+	if err = json.Unmarshal(j, &lc); err != nil || !lc.Snapshot().Equal(c) {
+		t.Fatal(err, lc.Snapshot())
+	}
+	b, err := lc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bc Counter[string]
+	if err = bc.UnmarshalBinary(b); err != nil || !bc.Equal(c) {
+		t.Fatal(err, bc)
+	}
+
+	type pair struct{ A, B int }
+	pc := CounterOf(pair{2, 1}, pair{1, 2}, pair{2, 1})
+	if j, err = json.Marshal(pc); err != nil || string(j) != `[{"key":{"A":1,"B":2},"count":1},{"key":{"A":2,"B":1},"count":2}]` {
+		t.Fatal(err, string(j))
+	}
+}
+
+func TestCounterMostCommon(t *testing.T) {
+	prop := func(keys []uint8, n uint8) bool {
+		c := CounterOf(keys...)
+		var all []CounterEntry[uint8]
+		for k, v := range c.All() {
+			all = append(all, CounterEntry[uint8]{k, v})
+		}
+		sort.Slice(all, func(i, j int) bool {
+			if all[i].Count != all[j].Count {
+				return all[i].Count > all[j].Count
+			}
+			return all[i].Key < all[j].Key
+		})
+		top := c.MostCommon(int(n % 20))
+		if n%20 != 0 && len(all) > int(n%20) {
+			all = all[:n%20]
+		}
+		return slices.Equal(top, all)
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Fatal(err)
+	}
+}
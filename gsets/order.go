@@ -0,0 +1,107 @@
+package gsets
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"unsafe"
+)
+
+// sortKeys sorts keys in their natural order if T is ordered, otherwise by their json encoding like marshalKeys,
+// with their %v representation breaking ties.
+func sortKeys[T comparable](keys []T) {
+	if fn := compareFunc[T](); fn != nil {
+		slices.SortFunc(keys, fn)
+		return
+	}
+	type kv struct {
+		enc, s string
+		k      T
+	}
+	tmp := make([]kv, len(keys))
+	for i, k := range keys {
+		b, _ := json.Marshal(k)
+		tmp[i] = kv{string(b), fmt.Sprintf("%v", k), k}
+	}
+	slices.SortFunc(tmp, func(a, b kv) int { return cmp.Or(cmp.Compare(a.enc, b.enc), cmp.Compare(a.s, b.s)) })
+	for i := range tmp {
+		keys[i] = tmp[i].k
+	}
+}
+
+// marshalKeys encodes every key to json and returns them as a json array ordered by their encoding,
+// it's used for types that don't have a natural order so the output is still deterministic.
+func marshalKeys[T comparable](keys []T) ([]byte, error) {
+	return marshalArray(keys, true)
+}
+
+// marshalArray encodes keys one by one as a json array, optionally sorted by their encoding,
+// so []uint8 keys don't get encoded as a base64 string.
+func marshalArray[T any](keys []T, sortEnc bool) ([]byte, error) {
+	enc := make([][]byte, len(keys))
+	for i, k := range keys {
+		b, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		enc[i] = b
+	}
+	if sortEnc {
+		slices.SortFunc(enc, bytes.Compare)
+	}
+	buf := append([]byte{'['}, bytes.Join(enc, []byte{','})...)
+	return append(buf, ']'), nil
+}
+
+var compareFuncs sync.Map // reflect.Type -> func(a, b T) int, nil for types without a natural order
+
+// compareFunc returns a compare func for T if it's an ordered type (integers, floats and strings, including named types
+// like `type ID int64`), nil otherwise, it's only resolved once per type.
+func compareFunc[T comparable]() func(a, b T) int {
+	t := reflect.TypeFor[T]()
+	if fn, ok := compareFuncs.Load(t); ok {
+		return fn.(func(a, b T) int)
+	}
+	var fn func(a, b T) int
+	switch t.Kind() {
+	case reflect.String:
+		fn = compareAs[T, string]
+	case reflect.Int:
+		fn = compareAs[T, int]
+	case reflect.Int8:
+		fn = compareAs[T, int8]
+	case reflect.Int16:
+		fn = compareAs[T, int16]
+	case reflect.Int32:
+		fn = compareAs[T, int32]
+	case reflect.Int64:
+		fn = compareAs[T, int64]
+	case reflect.Uint:
+		fn = compareAs[T, uint]
+	case reflect.Uint8:
+		fn = compareAs[T, uint8]
+	case reflect.Uint16:
+		fn = compareAs[T, uint16]
+	case reflect.Uint32:
+		fn = compareAs[T, uint32]
+	case reflect.Uint64:
+		fn = compareAs[T, uint64]
+	case reflect.Uintptr:
+		fn = compareAs[T, uintptr]
+	case reflect.Float32:
+		fn = compareAs[T, float32]
+	case reflect.Float64:
+		fn = compareAs[T, float64]
+	}
+	compareFuncs.Store(t, fn)
+	return fn
+}
+
+// compareAs compares a and b as U, T must have U as its underlying type.
+func compareAs[T any, U cmp.Ordered](a, b T) int {
+	return cmp.Compare(*(*U)(unsafe.Pointer(&a)), *(*U)(unsafe.Pointer(&b)))
+}
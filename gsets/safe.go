@@ -1,19 +1,19 @@
 package gsets
 
 import (
-	"encoding/json"
-	"sort"
+	"iter"
+	"slices"
 	"sync"
 
 	"go.oneofone.dev/genh/internal"
 )
 
-func SafeOf[T internal.Ordered](keys ...T) *SafeSet[T] {
+func SafeOf[T comparable](keys ...T) *SafeSet[T] {
 	s := Of(keys...)
 	return &SafeSet[T]{s: s}
 }
 
-type SafeSet[T internal.Ordered] struct {
+type SafeSet[T comparable] struct {
 	mux sync.RWMutex
 	s   Set[T]
 }
@@ -36,6 +36,56 @@ func (ss *SafeSet[T]) AddIfNotExists(key T) bool {
 	return added
 }
 
+// AddAll adds keys and returns the ones that weren't already in the set.
+func (ss *SafeSet[T]) AddAll(keys ...T) (added []T) {
+	ss.mux.Lock()
+	for _, k := range keys {
+		if ss.s.AddIfNotExists(k) {
+			added = append(added, k)
+		}
+	}
+	ss.mux.Unlock()
+	return added
+}
+
+// DeleteAll deletes keys and returns the ones that were in the set.
+func (ss *SafeSet[T]) DeleteAll(keys ...T) (deleted []T) {
+	ss.mux.Lock()
+	for _, k := range keys {
+		if _, ok := ss.s[k]; ok {
+			delete(ss.s, k)
+			deleted = append(deleted, k)
+		}
+	}
+	ss.mux.Unlock()
+	return deleted
+}
+
+// Pop removes and returns an arbitrary key, ok is false if the set is empty.
+func (ss *SafeSet[T]) Pop() (k T, ok bool) {
+	ss.mux.Lock()
+	for k = range ss.s {
+		delete(ss.s, k)
+		ok = true
+		break
+	}
+	ss.mux.Unlock()
+	return
+}
+
+// PopN removes and returns up to n arbitrary keys.
+func (ss *SafeSet[T]) PopN(n int) []T {
+	return ss.AppendPopN(nil, n)
+}
+
+// AppendPopN removes up to n arbitrary keys and appends them to dst.
+func (ss *SafeSet[T]) AppendPopN(dst []T, n int) []T {
+	ss.mux.Lock()
+	dst = ss.s.popN(n, dst)
+	ss.mux.Unlock()
+	return dst
+}
+
 func (ss *SafeSet[T]) Clone() *SafeSet[T] {
 	ss.mux.RLock()
 	ns := ss.s.Clone()
@@ -59,6 +109,93 @@ func (ss *SafeSet[T]) Merge(o Set[T]) *SafeSet[T] {
 	return ss
 }
 
+// IntersectWith removes all the keys that aren't in every one of os.
+func (ss *SafeSet[T]) IntersectWith(os ...Set[T]) *SafeSet[T] {
+	ss.mux.Lock()
+	ss.s.IntersectWith(os...)
+	ss.mux.Unlock()
+	return ss
+}
+
+// DifferenceWith removes all the keys of os.
+func (ss *SafeSet[T]) DifferenceWith(os ...Set[T]) *SafeSet[T] {
+	ss.mux.Lock()
+	ss.s.DifferenceWith(os...)
+	ss.mux.Unlock()
+	return ss
+}
+
+// SymmetricDifferenceWith toggles every key of os, see Set.SymmetricDifferenceWith.
+func (ss *SafeSet[T]) SymmetricDifferenceWith(os ...Set[T]) *SafeSet[T] {
+	ss.mux.Lock()
+	ss.s = ss.s.SymmetricDifferenceWith(os...)
+	ss.mux.Unlock()
+	return ss
+}
+
+// Union returns a new SafeSet with the keys of ss and all of os.
+func (ss *SafeSet[T]) Union(os ...Set[T]) *SafeSet[T] {
+	ss.mux.RLock()
+	ns := ss.s.Union(os...)
+	ss.mux.RUnlock()
+	return &SafeSet[T]{s: ns}
+}
+
+// Intersect returns a new SafeSet with the keys that are in ss and all of os.
+func (ss *SafeSet[T]) Intersect(os ...Set[T]) *SafeSet[T] {
+	ss.mux.RLock()
+	ns := ss.s.Intersect(os...)
+	ss.mux.RUnlock()
+	return &SafeSet[T]{s: ns}
+}
+
+// Difference returns a new SafeSet with the keys of ss that aren't in any of os.
+func (ss *SafeSet[T]) Difference(os ...Set[T]) *SafeSet[T] {
+	ss.mux.RLock()
+	ns := ss.s.Difference(os...)
+	ss.mux.RUnlock()
+	return &SafeSet[T]{s: ns}
+}
+
+// SymmetricDifference returns a new SafeSet with the keys that are in an odd number of ss and os.
+func (ss *SafeSet[T]) SymmetricDifference(os ...Set[T]) *SafeSet[T] {
+	ss.mux.RLock()
+	ns := ss.s.SymmetricDifference(os...)
+	ss.mux.RUnlock()
+	return &SafeSet[T]{s: ns}
+}
+
+func (ss *SafeSet[T]) IsSubset(o Set[T]) bool {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+	return ss.s.IsSubset(o)
+}
+
+func (ss *SafeSet[T]) IsSuperset(o Set[T]) bool {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+	return ss.s.IsSuperset(o)
+}
+
+func (ss *SafeSet[T]) Disjoint(o Set[T]) bool {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+	return ss.s.Disjoint(o)
+}
+
+func (ss *SafeSet[T]) Equal(o Set[T]) bool {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+	return ss.s.Equal(o)
+}
+
+// Snapshot returns a copy of the underlying set, it can be used to pass a SafeSet to the set operations.
+func (ss *SafeSet[T]) Snapshot() Set[T] {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+	return ss.s.Clone()
+}
+
 func (ss *SafeSet[T]) Delete(keys ...T) *SafeSet[T] {
 	ss.mux.Lock()
 	ss.s.Delete(keys...)
@@ -66,6 +203,12 @@ func (ss *SafeSet[T]) Delete(keys ...T) *SafeSet[T] {
 	return ss
 }
 
+func (ss *SafeSet[T]) Clear() {
+	ss.mux.Lock()
+	clear(ss.s)
+	ss.mux.Unlock()
+}
+
 func (ss *SafeSet[T]) Has(key T) bool {
 	ss.mux.RLock()
 	ok := ss.s.Has(key)
@@ -87,17 +230,38 @@ func (ss *SafeSet[T]) Keys() []T {
 	return keys
 }
 
+// Seq returns an iterator over the keys, the set is read-locked until the loop is done.
+func (ss *SafeSet[T]) Seq() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		ss.mux.RLock()
+		defer ss.mux.RUnlock()
+		for k := range ss.s {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// SortedKeys returns the keys in order, see Set.SortedKeys.
 func (ss *SafeSet[T]) SortedKeys() []T {
 	keys := ss.Keys()
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i] < keys[j]
-	})
+	sortKeys(keys)
 	return keys
 }
 
-func (ss *SafeSet[T]) MarshalJSON() ([]byte, error) {
+// SortedKeysFunc returns the keys ordered by cmp.
+func (ss *SafeSet[T]) SortedKeysFunc(cmp func(a, b T) int) []T {
 	keys := ss.Keys()
-	return json.Marshal(keys)
+	slices.SortFunc(keys, cmp)
+	return keys
+}
+
+// MarshalJSON encodes the set as a sorted json array, see Set.MarshalJSON.
+func (ss *SafeSet[T]) MarshalJSON() ([]byte, error) {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+	return ss.s.MarshalJSON()
 }
 
 func (ss *SafeSet[T]) UnmarshalJSON(data []byte) error {
@@ -6,20 +6,21 @@ import (
 	"fmt"
 	"iter"
 	"maps"
-	"sort"
+	"reflect"
+	"slices"
 	"unsafe"
 
 	"go.oneofone.dev/genh/internal"
 )
 
-func Of[T internal.Ordered](keys ...T) Set[T] {
+func Of[T comparable](keys ...T) Set[T] {
 	s := Set[T]{}
 	s.Add(keys...)
 	return s
 }
 
-// Set is a simple set.
-type Set[T internal.Ordered] map[T]struct{}
+// Set is a simple set of any comparable type.
+type Set[T comparable] map[T]struct{}
 
 func (s Set[T]) init() Set[T] {
 	if s == nil {
@@ -97,6 +98,19 @@ func (s Set[T]) Delete(keys ...T) Set[T] {
 	return s
 }
 
+// popN removes up to n keys from s and appends them to keys.
+func (s Set[T]) popN(n int, keys []T) []T {
+	for k := range s {
+		if n <= 0 {
+			break
+		}
+		delete(s, k)
+		keys = append(keys, k)
+		n--
+	}
+	return keys
+}
+
 func (s Set[T]) Has(key T) bool {
 	_, ok := s[key]
 	return ok
@@ -115,6 +129,140 @@ func (s Set[T]) Equal(os Set[T]) bool {
 	return true
 }
 
+// IntersectWith removes all the keys of s that aren't in every one of os, it doesn't allocate.
+func (s Set[T]) IntersectWith(os ...Set[T]) Set[T] {
+	for k := range s {
+		for _, o := range os {
+			if _, ok := o[k]; !ok {
+				delete(s, k)
+				break
+			}
+		}
+	}
+	return s
+}
+
+// DifferenceWith removes all the keys of os from s, it doesn't allocate.
+func (s Set[T]) DifferenceWith(os ...Set[T]) Set[T] {
+	for _, o := range os {
+		if len(o) < len(s) {
+			for k := range o {
+				delete(s, k)
+			}
+			continue
+		}
+		for k := range s {
+			if _, ok := o[k]; ok {
+				delete(s, k)
+			}
+		}
+	}
+	return s
+}
+
+// SymmetricDifferenceWith toggles every key of os in s,
+// so s ends up with the keys that are in an odd number of the sets.
+func (s Set[T]) SymmetricDifferenceWith(os ...Set[T]) Set[T] {
+	s = s.init()
+	for _, o := range os {
+		for k := range o {
+			if _, ok := s[k]; ok {
+				delete(s, k)
+			} else {
+				s[k] = struct{}{}
+			}
+		}
+	}
+	return s
+}
+
+// Union returns a new set with the keys of s and all of os.
+func (s Set[T]) Union(os ...Set[T]) Set[T] {
+	ln := len(s)
+	for _, o := range os {
+		ln = max(ln, len(o))
+	}
+	ns := make(Set[T], ln)
+	maps.Copy(ns, s)
+	return ns.Merge(os...)
+}
+
+// Intersect returns a new set with the keys that are in s and all of os, it iterates the smallest set.
+func (s Set[T]) Intersect(os ...Set[T]) Set[T] {
+	small := s
+	for _, o := range os {
+		if len(o) < len(small) {
+			small = o
+		}
+	}
+	ns := Set[T]{}
+L:
+	for k := range small {
+		if _, ok := s[k]; !ok {
+			continue
+		}
+		for _, o := range os {
+			if _, ok := o[k]; !ok {
+				continue L
+			}
+		}
+		ns[k] = struct{}{}
+	}
+	return ns
+}
+
+// Difference returns a new set with the keys of s that aren't in any of os.
+func (s Set[T]) Difference(os ...Set[T]) Set[T] {
+	ns := Set[T]{}
+L:
+	for k := range s {
+		for _, o := range os {
+			if _, ok := o[k]; ok {
+				continue L
+			}
+		}
+		ns[k] = struct{}{}
+	}
+	return ns
+}
+
+// SymmetricDifference returns a new set with the keys that are in an odd number of s and os,
+// for 2 sets that's the keys that are in either but not both.
+func (s Set[T]) SymmetricDifference(os ...Set[T]) Set[T] {
+	return s.Clone().SymmetricDifferenceWith(os...)
+}
+
+// IsSubset returns true if every key of s is in o.
+func (s Set[T]) IsSubset(o Set[T]) bool {
+	if len(s) > len(o) {
+		return false
+	}
+	for k := range s {
+		if _, ok := o[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSuperset returns true if every key of o is in s.
+func (s Set[T]) IsSuperset(o Set[T]) bool {
+	return o.IsSubset(s)
+}
+
+// Disjoint returns true if s and o have no keys in common, it iterates the smaller set.
+func (s Set[T]) Disjoint(o Set[T]) bool {
+	if len(o) < len(s) {
+		s, o = o, s
+	}
+	for k := range s {
+		if _, ok := o[k]; ok {
+			return false
+		}
+	}
+	return true
+}
+
 func (s Set[T]) Len() int {
 	return len(s)
 }
@@ -130,11 +278,29 @@ func (s Set[T]) Keys() []T {
 	return keys
 }
 
+// Seq returns an iterator over the keys, the set must not be modified during the loop.
+func (s Set[T]) Seq() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for k := range s {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// SortedKeys returns the keys in their natural order if T is an integer, float or string type,
+// any other type is ordered by its json encoding, the same order MarshalJSON uses, use SortedKeysFunc for a custom order.
 func (s Set[T]) SortedKeys() []T {
 	keys := s.Keys()
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i] < keys[j]
-	})
+	sortKeys(keys)
+	return keys
+}
+
+// SortedKeysFunc returns the keys ordered by cmp.
+func (s Set[T]) SortedKeysFunc(cmp func(a, b T) int) []T {
+	keys := s.Keys()
+	slices.SortFunc(keys, cmp)
 	return keys
 }
 
@@ -151,7 +317,7 @@ func (s Set[T]) append(buf *bytes.Buffer, sorted bool) *bytes.Buffer {
 		keys = s.Keys()
 	}
 
-	_, isString := any(keys[0]).(string)
+	isString := reflect.TypeFor[T]().Kind() == reflect.String
 
 	verb := "%v"
 	if isString {
@@ -178,10 +344,15 @@ func (s Set[T]) String() string {
 	return *(*string)(unsafe.Pointer(&buf))
 }
 
+// MarshalJSON encodes the set as a sorted json array, types without a natural order are sorted by their json encoding.
 func (s Set[T]) MarshalJSON() ([]byte, error) {
-	buf := s.append(&bytes.Buffer{}, true).Bytes()
-	buf = buf[:len(buf):len(buf)]
-	return buf, nil
+	if len(s) == 0 {
+		return []byte("[]"), nil
+	}
+	if compareFunc[T]() == nil {
+		return marshalKeys(s.Keys())
+	}
+	return marshalArray(s.SortedKeys(), false)
 }
 
 func (s *Set[T]) UnmarshalJSON(data []byte) (err error) {
@@ -0,0 +1,167 @@
+package gsets
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"testing"
+	"testing/quick"
+)
+
+// naive returns the keys of a and b that pass keep.
+func naive(a, b []int8, keep func(inA, inB bool) bool) Set[int8] {
+	s, sa, sb := Set[int8]{}, Of(a...), Of(b...)
+	for _, k := range append(append([]int8{}, a...), b...) {
+		if keep(sa.Has(k), sb.Has(k)) {
+			s.Add(k)
+		}
+	}
+	return s
+}
+
+func TestSetAlgebra(t *testing.T) {
+	ops := map[string]func(a, b []int8) bool{
+		"union": func(a, b []int8) bool {
+			sa, sb := Of(a...), Of(b...)
+			exp := naive(a, b, func(inA, inB bool) bool { return inA || inB })
+			return sa.Union(sb).Equal(exp) && sa.Equal(Of(a...)) && sa.Clone().Merge(sb).Equal(exp)
+		},
+		"intersect": func(a, b []int8) bool {
+			sa, sb := Of(a...), Of(b...)
+			exp := naive(a, b, func(inA, inB bool) bool { return inA && inB })
+			return sa.Intersect(sb).Equal(exp) && sb.Intersect(sa).Equal(exp) &&
+				sa.Equal(Of(a...)) && sa.Clone().IntersectWith(sb).Equal(exp)
+		},
+		"difference": func(a, b []int8) bool {
+			sa, sb := Of(a...), Of(b...)
+			exp := naive(a, b, func(inA, inB bool) bool { return inA && !inB })
+			return sa.Difference(sb).Equal(exp) && sa.Equal(Of(a...)) && sa.Clone().DifferenceWith(sb).Equal(exp)
+		},
+		"symmetric difference": func(a, b []int8) bool {
+			sa, sb := Of(a...), Of(b...)
+			exp := naive(a, b, func(inA, inB bool) bool { return inA != inB })
+			return sa.SymmetricDifference(sb).Equal(exp) && sa.Equal(Of(a...)) &&
+				sa.Clone().SymmetricDifferenceWith(sb).Equal(exp) &&
+				sa.Difference(sb).Union(sb.Difference(sa)).Equal(exp)
+		},
+		"identities": func(a, b []int8) bool {
+			sa, sb := Of(a...), Of(b...)
+			in, diff := sa.Intersect(sb), sa.Difference(sb)
+			return in.IsSubset(sa) && in.IsSubset(sb) && sa.IsSuperset(in) &&
+				diff.Disjoint(sb) && diff.Union(in).Equal(sa) &&
+				sa.Union(sb).Len() == sa.Len()+sb.Len()-in.Len() &&
+				sa.IsSubset(sb) == (sa.Difference(sb).Len() == 0) &&
+				sa.Disjoint(sb) == (in.Len() == 0)
+		},
+		"variadic": func(a, b []int8) bool {
+			sa, sb, sc := Of(a...), Of(b...), Of(a[len(a)/2:]...).Add(b[len(b)/2:]...)
+			return sa.Union(sb, sc).Equal(sa.Union(sb).Union(sc)) &&
+				sa.Intersect(sb, sc).Equal(sa.Intersect(sb).Intersect(sc)) &&
+				sa.Clone().IntersectWith(sb, sc).Equal(sa.Intersect(sb, sc)) &&
+				sa.Difference(sb, sc).Equal(sa.Difference(sb).Difference(sc)) &&
+				sa.Clone().DifferenceWith(sb, sc).Equal(sa.Difference(sb, sc)) &&
+				sa.SymmetricDifference(sb, sc).Equal(sa.SymmetricDifference(sb).SymmetricDifference(sc))
+		},
+		"safe": func(a, b []int8) bool {
+			ss, sa, sb := SafeOf(a...), Of(a...), Of(b...)
+			return ss.Union(sb).Equal(sa.Union(sb)) &&
+				ss.Intersect(sb).Equal(sa.Intersect(sb)) &&
+				ss.Difference(sb).Equal(sa.Difference(sb)) &&
+				ss.SymmetricDifference(sb).Equal(sa.SymmetricDifference(sb)) &&
+				ss.IsSubset(sb) == sa.IsSubset(sb) && ss.IsSuperset(sb) == sa.IsSuperset(sb) &&
+				ss.Disjoint(sb) == sa.Disjoint(sb) &&
+				ss.Clone().IntersectWith(sb).Equal(sa.Intersect(sb)) &&
+				ss.Clone().DifferenceWith(sb).Equal(sa.Difference(sb)) &&
+				ss.Clone().SymmetricDifferenceWith(sb).Equal(sa.SymmetricDifference(sb)) &&
+				ss.Snapshot().Equal(sa)
+		},
+	}
+	for name, fn := range ops {
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(name, err)
+		}
+	}
+}
+
+func TestSetAlgebraNil(t *testing.T) {
+	var s Set[int]
+	if s.IntersectWith(Of(1)).Len() != 0 || s.DifferenceWith(Of(1)).Len() != 0 || !s.IsSubset(nil) || !s.Disjoint(Of(1)) {
+		t.Fatal("unexpected nil set behavior")
+	}
+	if s = s.SymmetricDifferenceWith(Of(1, 2)); !s.Equal(Of(1, 2)) {
+		t.Fatal("unexpected", s)
+	}
+	var ss SafeSet[int]
+	if !ss.SymmetricDifferenceWith(Of(1)).Equal(Of(1)) || ss.Union(nil).Len() != 1 {
+		t.Fatal("unexpected", ss.Keys())
+	}
+}
+
+func TestSetComparable(t *testing.T) {
+	type point struct{ X, Y int }
+	s := Of(point{2, 1}, point{1, 2}, point{1, 1})
+	s.Add(point{1, 2})
+	if s.Len() != 3 || !s.Has(point{1, 1}) {
+		t.Fatal("unexpected", s)
+	}
+	exp := []point{{1, 1}, {1, 2}, {2, 1}}
+	if keys := s.SortedKeys(); !slices.Equal(keys, exp) {
+		t.Fatal("unexpected order", keys)
+	}
+	byY := func(a, b point) int { return cmp.Or(cmp.Compare(a.Y, b.Y), cmp.Compare(a.X, b.X)) }
+	if keys := SafeOf(exp...).SortedKeysFunc(byY); !slices.Equal(keys, []point{{1, 1}, {2, 1}, {1, 2}}) {
+		t.Fatal("unexpected order", keys)
+	}
+	for range 10 { // map order is random, the output must not be
+		j, err := json.Marshal(s)
+		if err != nil || string(j) != `[{"X":1,"Y":1},{"X":1,"Y":2},{"X":2,"Y":1}]` {
+			t.Fatal(err, string(j))
+		}
+	}
+	var js SafeSet[point]
+	if err := json.Unmarshal([]byte(`[{"X":1,"Y":1},{"X":1,"Y":1},{"X":3}]`), &js); err != nil || !js.Snapshot().Equal(Of(point{1, 1}, point{3, 0})) {
+		t.Fatal(err, js.Keys())
+	}
+
+	as := Of([2]string{"b", "a"}, [2]string{"a", "b"})
+	if j, err := json.Marshal(as); err != nil || string(j) != `[["a","b"],["b","a"]]` {
+		t.Fatal(err, string(j))
+	}
+
+	type sk struct {
+		S string
+		N int
+	}
+	sks := Of(sk{"a", 1}, sk{"a!", 1}, sk{"b", 0}) // %v and json order "a" and "a!" differently
+	var jks []sk
+	if j, err := json.Marshal(sks); err != nil || json.Unmarshal(j, &jks) != nil || !slices.Equal(jks, sks.SortedKeys()) {
+		t.Fatal("SortedKeys and MarshalJSON orders differ", err, sks.SortedKeys(), jks)
+	}
+
+	type id int64
+	if ids := Of[id](10, -1, 2).SortedKeys(); !slices.Equal(ids, []id{-1, 2, 10}) {
+		t.Fatal("unexpected order", ids)
+	}
+	type name string
+	if j, err := json.Marshal(Of[name]("b", "a")); err != nil || string(j) != `["a","b"]` {
+		t.Fatal(err, string(j))
+	}
+}
+
+func TestSetJSONEscaping(t *testing.T) {
+	keys := []string{"a\x00b", "tab\there", "quote\"", "bad\xffutf8", "<html>", "line sep"}
+	for _, v := range []json.Marshaler{Of(keys...), SafeOf(keys...), ShardedOf(keys...)} {
+		j, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, _ := json.Marshal(Of(keys...).SortedKeys())
+		if string(j) != string(exp) {
+			t.Fatal("unexpected", string(j), string(exp))
+		}
+		var s Set[string]
+		if err = json.Unmarshal(j, &s); err != nil || s.Len() != len(keys) || !s.Has("a\x00b") || !s.Has("tab\there") {
+			t.Fatal(err, s)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package gsets
+
+import (
+	"hash/maphash"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+func NewShardedSet[T comparable](shards int) *ShardedSet[T] {
+	var ss ShardedSet[T]
+	ss.init(shards)
+	return &ss
+}
+
+func ShardedOf[T comparable](keys ...T) *ShardedSet[T] {
+	var ss ShardedSet[T]
+	ss.Add(keys...)
+	return &ss
+}
+
+// ShardedSet is a concurrent set that spreads its keys over multiple SafeSets to lower the lock contention
+// of write heavy workloads, operations that span multiple shards (Len, Keys, Snapshot, etc) aren't atomic.
+// It uses the same json and binary encodings as SafeSet, the zero value is ready to use and uses NumCPU shards.
+type ShardedSet[T comparable] struct {
+	ss   []SafeSet[T]
+	seed maphash.Seed
+	o    sync.Once
+}
+
+func (ss *ShardedSet[T]) init(n int) {
+	ss.o.Do(func() {
+		if n < 1 {
+			n = runtime.NumCPU()
+		}
+		ss.ss = make([]SafeSet[T], n)
+		ss.seed = maphash.MakeSeed()
+	})
+}
+
+func (ss *ShardedSet[T]) idx(k T) int {
+	return int(maphash.Comparable(ss.seed, k) % uint64(len(ss.ss)))
+}
+
+func (ss *ShardedSet[T]) shard(k T) *SafeSet[T] {
+	ss.init(0)
+	return &ss.ss[ss.idx(k)]
+}
+
+// group returns keys grouped by their shard index.
+func (ss *ShardedSet[T]) group(keys []T) [][]T {
+	ss.init(0)
+	g := make([][]T, len(ss.ss))
+	for _, k := range keys {
+		i := ss.idx(k)
+		g[i] = append(g[i], k)
+	}
+	return g
+}
+
+func (ss *ShardedSet[T]) Set(keys ...T) *ShardedSet[T] {
+	return ss.Add(keys...)
+}
+
+func (ss *ShardedSet[T]) Add(keys ...T) *ShardedSet[T] {
+	for i, keys := range ss.group(keys) {
+		if len(keys) > 0 {
+			ss.ss[i].Add(keys...)
+		}
+	}
+	return ss
+}
+
+func (ss *ShardedSet[T]) AddIfNotExists(key T) bool {
+	return ss.shard(key).AddIfNotExists(key)
+}
+
+// AddAll adds keys and returns the ones that weren't already in the set, each shard is locked once.
+func (ss *ShardedSet[T]) AddAll(keys ...T) (added []T) {
+	for i, keys := range ss.group(keys) {
+		if len(keys) > 0 {
+			added = append(added, ss.ss[i].AddAll(keys...)...)
+		}
+	}
+	return added
+}
+
+func (ss *ShardedSet[T]) Delete(keys ...T) *ShardedSet[T] {
+	for i, keys := range ss.group(keys) {
+		if len(keys) > 0 {
+			ss.ss[i].Delete(keys...)
+		}
+	}
+	return ss
+}
+
+// DeleteAll deletes keys and returns the ones that were in the set, each shard is locked once.
+func (ss *ShardedSet[T]) DeleteAll(keys ...T) (deleted []T) {
+	for i, keys := range ss.group(keys) {
+		if len(keys) > 0 {
+			deleted = append(deleted, ss.ss[i].DeleteAll(keys...)...)
+		}
+	}
+	return deleted
+}
+
+func (ss *ShardedSet[T]) Has(key T) bool {
+	return ss.shard(key).Has(key)
+}
+
+// Pop removes and returns an arbitrary key, ok is false if the set is empty.
+func (ss *ShardedSet[T]) Pop() (k T, ok bool) {
+	ss.init(0)
+	for i := range ss.ss {
+		if k, ok = ss.ss[i].Pop(); ok {
+			return
+		}
+	}
+	return
+}
+
+// PopN removes and returns up to n arbitrary keys.
+func (ss *ShardedSet[T]) PopN(n int) (keys []T) {
+	ss.init(0)
+	for i := range ss.ss {
+		if len(keys) >= n {
+			break
+		}
+		keys = ss.ss[i].AppendPopN(keys, n-len(keys))
+	}
+	return keys
+}
+
+func (ss *ShardedSet[T]) Merge(o Set[T]) *ShardedSet[T] {
+	return ss.Add(o.Keys()...)
+}
+
+func (ss *ShardedSet[T]) Clear() {
+	ss.init(0)
+	for i := range ss.ss {
+		ss.ss[i].Clear()
+	}
+}
+
+func (ss *ShardedSet[T]) Len() (n int) {
+	ss.init(0)
+	for i := range ss.ss {
+		n += ss.ss[i].Len()
+	}
+	return n
+}
+
+func (ss *ShardedSet[T]) Keys() (keys []T) {
+	for k := range ss.Seq() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// SortedKeys returns the keys in order, see Set.SortedKeys.
+func (ss *ShardedSet[T]) SortedKeys() []T {
+	keys := ss.Keys()
+	sortKeys(keys)
+	return keys
+}
+
+// SortedKeysFunc returns the keys ordered by cmp.
+func (ss *ShardedSet[T]) SortedKeysFunc(cmp func(a, b T) int) []T {
+	keys := ss.Keys()
+	slices.SortFunc(keys, cmp)
+	return keys
+}
+
+// Seq returns an iterator over the keys, each shard is read-locked while its keys are being iterated.
+func (ss *ShardedSet[T]) Seq() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		ss.init(0)
+		for i := range ss.ss {
+			for k := range ss.ss[i].Seq() {
+				if !yield(k) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Snapshot returns a copy of the set as a plain Set.
+func (ss *ShardedSet[T]) Snapshot() Set[T] {
+	s := make(Set[T], ss.Len())
+	for k := range ss.Seq() {
+		s[k] = struct{}{}
+	}
+	return s
+}
+
+func (ss *ShardedSet[T]) MarshalJSON() ([]byte, error) {
+	return ss.Snapshot().MarshalJSON()
+}
+
+// UnmarshalJSON replaces the keys of the set, the same as SafeSet.UnmarshalJSON.
+func (ss *ShardedSet[T]) UnmarshalJSON(data []byte) error {
+	var s Set[T]
+	if err := s.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	ss.Clear()
+	ss.Merge(s)
+	return nil
+}
+
+func (ss *ShardedSet[T]) MarshalBinary() ([]byte, error) {
+	return ss.Snapshot().MarshalBinary()
+}
+
+// UnmarshalBinary replaces the keys of the set, the same as SafeSet.UnmarshalBinary.
+func (ss *ShardedSet[T]) UnmarshalBinary(data []byte) error {
+	var s Set[T]
+	if err := s.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	ss.Clear()
+	ss.Merge(s)
+	return nil
+}
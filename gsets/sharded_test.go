@@ -0,0 +1,100 @@
+package gsets
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func TestShardedSet(t *testing.T) {
+	const n, workers = 1000, 8
+	var ss ShardedSet[int]
+	var wg sync.WaitGroup
+	added := make([][]int, workers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys := make([]int, n)
+			for i := range keys {
+				keys[i] = (i * (w + 1)) % n
+			}
+			added[w] = ss.AddAll(keys...)
+		}()
+	}
+	wg.Wait()
+	total := Set[int]{}
+	for _, keys := range added {
+		for _, k := range keys {
+			if !total.AddIfNotExists(k) {
+				t.Fatal("key added twice", k)
+			}
+		}
+	}
+	if len(total) != n || ss.Len() != n || !ss.Snapshot().Equal(total) {
+		t.Fatal("unexpected len", len(total), ss.Len())
+	}
+
+	if deleted := ss.DeleteAll(1, 2, 3, -1, 1); len(deleted) != 3 || ss.Has(2) {
+		t.Fatal("unexpected", deleted)
+	}
+	popped := make([][]int, workers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				keys := ss.PopN(7)
+				if len(keys) == 0 {
+					return
+				}
+				popped[w] = append(popped[w], keys...)
+			}
+		}()
+	}
+	wg.Wait()
+	total = Set[int]{}
+	for _, keys := range popped {
+		total.Add(keys...)
+	}
+	if len(total) != n-3 || ss.Len() != 0 {
+		t.Fatal("unexpected len", len(total), ss.Len())
+	}
+	if _, ok := ss.Pop(); ok {
+		t.Fatal("expected an empty set")
+	}
+
+	ss.Add(3, 1, 2)
+	sf := SafeOf(3, 1, 2)
+	j, err := json.Marshal(&ss)
+	if sj, _ := json.Marshal(sf); err != nil || string(j) != string(sj) {
+		t.Fatal(err, string(j), string(sj))
+	}
+	var js SafeSet[int]
+	if err = json.Unmarshal(j, &js); err != nil || !js.Equal(ss.Snapshot()) {
+		t.Fatal(err, js.Keys())
+	}
+	b, err := sf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := NewShardedSet[int](3)
+	bs.Add(10)
+	if err = bs.UnmarshalBinary(b); err != nil || !bs.Snapshot().Equal(Of(1, 2, 3)) {
+		t.Fatal(err, bs.Keys())
+	}
+	if k, ok := bs.Pop(); !ok || bs.Has(k) || bs.Len() != 2 {
+		t.Fatal("unexpected", k, ok, bs.Keys())
+	}
+	bs.Clear()
+	if bs.Len() != 0 {
+		t.Fatal("expected an empty set", bs.Keys())
+	}
+
+	if keys := sf.AppendPopN([]int{0}, 2); len(keys) != 3 || keys[0] != 0 || sf.Len() != 1 || sf.Has(keys[1]) {
+		t.Fatal("unexpected", keys, sf.Keys())
+	}
+	if sf.Clear(); sf.Len() != 0 {
+		t.Fatal("expected an empty set", sf.Keys())
+	}
+}
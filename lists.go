@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding"
 	"encoding/json"
+	"iter"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -33,11 +34,15 @@ func ListOf[T any](vs ...T) (l List[T]) {
 	return l.Append(vs...)
 }
 
+// List is a singly linked list, copying a List value shares its nodes.
+// Clip and ListAt return views that share their nodes with the original list,
+// pushing to a view never modifies the original and pushing to the original never changes a view.
 type List[T any] struct {
-	head *listNode[T]
-	tail *listNode[T]
-	clip *listNode[T]
-	len  int
+	head  *listNode[T]
+	tail  *listNode[T]
+	clip  *listNode[T]
+	cnext *listNode[T] // the node after clip in this view, clip.next belongs to the original list
+	len   int
 }
 
 func (l List[T]) Len() int {
@@ -69,12 +74,16 @@ func (l List[T]) get(idx int) (n *listNode[T]) {
 
 	n = l.head
 	for i := 0; i < idx; i++ {
-		n = n.next
+		n = l.nextNode(n)
 	}
 	return
 }
 
+// ListAt returns a clipped view of the elements from start to end (inclusive), a negative end counts from the tail.
 func (l List[T]) ListAt(start, end int) List[T] {
+	if l.clip != nil && l.clip != l.tail {
+		l = l.Clone() // see Clip
+	}
 	h := l.get(start)
 	if end >= l.len {
 		end = l.len - 1
@@ -130,8 +139,12 @@ func (l *List[T]) Merge(ol *List[T]) {
 		}
 		return
 	}
+	if ol.clip != nil { // the nodes after ol.clip belong to another list
+		c := ol.Clone()
+		ol = &c
+	}
 	l.len += ol.len
-	l.tail.next = ol.head
+	l.setNext(l.tail, ol.head)
 	l.tail = ol.tail
 }
 
@@ -170,30 +183,29 @@ func (l *List[T]) pushNode(n *listNode[T]) {
 		return
 	}
 
-	if l.clip == l.tail {
-		tn := *l.tail
-		l.tail = &tn
-	}
-	l.tail.next = n
+	l.setNext(l.tail, n)
 	l.tail = n
 }
 
+// setNext links nn after n in this list without touching the nodes shared with the original list.
+func (l *List[T]) setNext(n, nn *listNode[T]) {
+	if n == l.clip {
+		l.cnext = nn
+		return
+	}
+	n.next = nn
+}
+
 func (l List[T]) nextNode(n *listNode[T]) *listNode[T] {
 	if l.clip == n {
-		if l.clip == l.tail {
-			return nil
-		}
-		return l.tail
+		return l.cnext
 	}
 	return n.next
 }
 
-func (l List[T]) nextNodePtr(n *listNode[T]) **listNode[T] {
+func (l *List[T]) nextNodePtr(n *listNode[T]) **listNode[T] {
 	if l.clip == n {
-		if l.clip == l.tail {
-			return nil
-		}
-		return &l.tail
+		return &l.cnext
 	}
 	return &n.next
 }
@@ -240,8 +252,13 @@ func (l List[T]) count() (out int) {
 	return
 }
 
+// Clip returns a view of l that can be pushed to without modifying l, see List.
 func (l List[T]) Clip() List[T] {
-	l.clip = l.tail
+	if l.clip != nil && l.clip != l.tail {
+		// l is a view that was pushed to, its old clip can't be tracked along with the new one.
+		l = l.Clone()
+	}
+	l.clip, l.cnext = l.tail, nil
 	return l
 }
 
@@ -253,11 +270,11 @@ func (l List[T]) Safe() *LList[T] {
 	return &LList[T]{l: l}
 }
 
-// Iter is a c++-style iterator:
+// Iter returns an iterator that can modify l during the traversal, see ListIterator:
 // it := l.Iter()
-// for v := it.Value(); it.Next(); v = it.Value()) {}
+// for v, ok := it.Next(); ok; v, ok = it.Next() {}
 func (l *List[T]) Iter() *ListIterator[T] {
-	return &ListIterator[T]{l: l, n: l.head}
+	return &ListIterator[T]{l: l}
 }
 
 func (l List[T]) IterChan(cap int) <-chan T {
@@ -274,6 +291,46 @@ func (l List[T]) IterChan(cap int) <-chan T {
 	return ch
 }
 
+// All returns an iterator over the indices and values, the list must not be modified during the loop.
+func (l List[T]) All() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		i := 0
+		for n := l.head; n != nil; n = l.nextNode(n) {
+			if !yield(i, n.v) {
+				return
+			}
+			i++
+		}
+	}
+}
+
+// Backward returns an iterator over the indices and values from the tail to the head, the list must not be modified during the loop.
+// The list is singly linked, so the nodes are collected before the loop starts.
+func (l List[T]) Backward() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		nodes := make([]*listNode[T], 0, l.len)
+		for n := l.head; n != nil; n = l.nextNode(n) {
+			nodes = append(nodes, n)
+		}
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if !yield(i, nodes[i].v) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the values, the list must not be modified during the loop.
+func (l List[T]) Values() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for n := l.head; n != nil; n = l.nextNode(n) {
+			if !yield(n.v) {
+				return
+			}
+		}
+	}
+}
+
 func (l List[T]) ForEach(fn func(v T) bool) {
 	for n := l.head; n != nil; n = l.nextNode(n) {
 		if !fn(n.v) {
@@ -352,33 +409,121 @@ func (l *List[T]) DecodeMsgpack(dec *msgpack.Decoder) (err error) {
 	return
 }
 
+// ListIterator iterates a List and can modify it during the traversal, its cursor sits between elements:
+//   - Next returns the element after the cursor and moves the cursor past it.
+//   - Set replaces the value of the element returned by the last Next.
+//   - Delete removes the element returned by the last Next, Next then returns the element that followed it.
+//   - Insert adds a value at the cursor, before the element Next would return, so Next doesn't return it.
+//
+// Set and Delete do nothing if there's no current element: before the first Next, or after a Delete or an Insert,
+// TrySet and TryDelete are the same but return false in that case.
+//
+// A clipped list (Clip, ListAt) shares its nodes with the list it came from, Set writes through to them,
+// but the first Delete or Insert copies the clipped list, so the original list is never relinked.
+// Deleting or inserting through an iterator of the original list invalidates the views that share the modified nodes,
+// and modifying the list other than through the iterator during the traversal invalidates the iterator.
 type ListIterator[T any] struct { // i hate how much this feels like java/c++
 	l    *List[T]
-	n    *listNode[T]
-	prev *listNode[T]
+	prev *listNode[T] // the node before the cursor, nil if the cursor is at the head
+	cur  *listNode[T] // the node returned by the last Next, it's always prev if it's set
+	pcur *listNode[T] // the node before cur
+}
+
+func (it *ListIterator[T]) peek() *listNode[T] {
+	if it.prev == nil {
+		return it.l.head
+	}
+	return it.l.nextNode(it.prev)
 }
 
 func (it *ListIterator[T]) Next() (v T, ok bool) {
-	if ok = it.n != nil; !ok {
+	n := it.peek()
+	if ok = n != nil; !ok {
 		return
 	}
-	it.prev = it.n
-	v, it.n = it.n.v, it.l.nextNode(it.n)
-	return
+	it.pcur, it.prev, it.cur = it.prev, n, n
+	return n.v, true
 }
 
+// Set replaces the value of the element returned by the last Next.
 func (it *ListIterator[T]) Set(v T) {
-	it.prev.v = v
+	it.TrySet(v)
+}
+
+// TrySet is Set but returns false if there's no current element.
+func (it *ListIterator[T]) TrySet(v T) bool {
+	if it.cur == nil {
+		return false
+	}
+	it.cur.v = v
+	return true
 }
 
+// Delete removes the element returned by the last Next.
 func (it *ListIterator[T]) Delete() {
-	it.l.len--
-	if it.l.head == it.prev {
-		it.l.head = it.n
+	it.TryDelete()
+}
+
+// TryDelete is Delete but returns false if there's no current element.
+func (it *ListIterator[T]) TryDelete() bool {
+	if it.cur == nil {
+		return false
+	}
+	it.own()
+	l, n := it.l, it.cur
+	if it.pcur == nil {
+		l.head = n.next
+	} else {
+		it.pcur.next = n.next
+	}
+	if l.tail == n {
+		l.tail = it.pcur
+	}
+	l.len--
+	it.prev, it.cur = it.pcur, nil
+	return true
+}
+
+// Insert adds v at the cursor, the next call to Next returns the same element it would have without the insert.
+func (it *ListIterator[T]) Insert(v T) {
+	it.own()
+	l := it.l
+	nn := &listNode[T]{v: v, next: it.peek()}
+	if it.prev == nil {
+		l.head = nn
 	} else {
-		it.prev.next = it.n.next
-		it.prev.v = it.n.v
+		it.prev.next = nn
+	}
+	if nn.next == nil {
+		l.tail = nn
+	}
+	l.len++
+	it.prev, it.cur = nn, nil
+}
+
+// own replaces a clipped list with a copy of its nodes before its links are modified.
+func (it *ListIterator[T]) own() {
+	l := it.l
+	if l.clip == nil {
+		return
+	}
+	var nl List[T]
+	prev, pcur := it.prev, it.pcur
+	for n := l.head; n != nil; n = l.nextNode(n) {
+		nn := &listNode[T]{v: n.v}
+		nl.pushNode(nn)
+		if n == prev {
+			it.prev = nn
+		}
+		if n == pcur {
+			it.pcur = nn
+		}
+	}
+	if it.cur != nil {
+		it.cur = it.prev
 	}
+	nl.len = l.len
+	*l = nl
 }
 
 func ListToMap[K comparable, V any](l List[V], keyFn func(v V) K) map[K]V {
@@ -5,6 +5,7 @@ import (
 	"log"
 	"math/rand"
 	"sort"
+	"strconv"
 	"sync"
 	"testing"
 )
@@ -254,3 +255,319 @@ func TestListClip(t *testing.T) {
 // 		_ = sink
 // 	})
 // }
+
+func TestListSeq(t *testing.T) {
+	l := ListOf(1, 2, 3, 4, 5)
+	var got []int
+	for i, v := range l.All() {
+		if v != i+1 {
+			t.Fatal("unexpected", i, v)
+		}
+		if got = append(got, v); v == 3 {
+			break
+		}
+	}
+	if len(got) != 3 {
+		t.Fatal("expected 3 values, got", got)
+	}
+
+	cl := l.Clip()
+	l.Push(6)
+	got = got[:0]
+	for v := range cl.Values() {
+		got = append(got, v)
+	}
+	if len(got) != 5 {
+		t.Fatal("clipped list should have 5 values, got", got)
+	}
+
+	ll := l.Safe()
+	sum := 0
+	for v := range ll.Values() {
+		sum += v
+	}
+	for i, v := range ll.All() {
+		sum -= i + 1
+		sum -= v
+	}
+	if sum != -21 {
+		t.Fatal("unexpected sum", sum)
+	}
+
+	var ls LSlice[int]
+	ls.Append(1, 2, 3)
+	for i, v := range ls.All() {
+		if v != i+1 {
+			t.Fatal("unexpected", i, v)
+		}
+	}
+	sum = 0
+	for v := range ls.Values() {
+		sum += v
+	}
+	if sum != 6 {
+		t.Fatal("unexpected sum", sum)
+	}
+}
+
+func TestDList(t *testing.T) {
+	var l DList[int]
+	var ref []int
+	var elems []*DElem[int] // same order as ref
+	check := func(op string) {
+		t.Helper()
+		if l.Len() != len(ref) || !Equal(l.Slice(), ref) {
+			t.Fatal(op, "mismatch", l.Slice(), ref)
+		}
+		var rev []int
+		for v := range l.Backward() {
+			rev = append(rev, v)
+		}
+		for i, v := range rev {
+			if v != ref[len(ref)-1-i] {
+				t.Fatal(op, "backward mismatch", rev, ref)
+			}
+		}
+		for i, e := range elems {
+			if e.Value != ref[i] || (i > 0) != (e.Prev() != nil) || (i < len(elems)-1) != (e.Next() != nil) {
+				t.Fatal(op, "handle mismatch", i)
+			}
+		}
+	}
+	rnd := xorshift(7)
+	for i := 0; i < 2000; i++ {
+		op := rnd.Next() % 8
+		if len(ref) == 0 {
+			op %= 2
+		}
+		j := 0
+		if len(ref) > 0 {
+			j = int(rnd.Next() % uint64(len(ref)))
+		}
+		switch op {
+		case 0:
+			elems = append(elems, l.PushBack(i))
+			ref = append(ref, i)
+		case 1:
+			elems = Insert(elems, 0, l.PushFront(i))
+			ref = Insert(ref, 0, i)
+		case 2:
+			elems = Insert(elems, j, l.InsertBefore(i, elems[j]))
+			ref = Insert(ref, j, i)
+		case 3:
+			elems = Insert(elems, j+1, l.InsertAfter(i, elems[j]))
+			ref = Insert(ref, j+1, i)
+		case 4:
+			if v, ok := l.Remove(elems[j]); !ok || v != ref[j] {
+				t.Fatal("remove", v, ok)
+			}
+			if _, ok := l.Remove(elems[j]); ok {
+				t.Fatal("removed twice")
+			}
+			elems, ref = Delete(elems, j, j+1), Delete(ref, j, j+1)
+		case 5:
+			e := elems[j]
+			l.MoveToFront(e)
+			elems = Insert(Delete(elems, j, j+1), 0, e)
+			ref = Insert(Delete(ref, j, j+1), 0, e.Value)
+		case 6:
+			e := elems[j]
+			l.MoveToBack(e)
+			elems = append(Delete(elems, j, j+1), e)
+			ref = append(Delete(ref, j, j+1), e.Value)
+		case 7:
+			if rnd.Next()%2 == 0 {
+				v, _ := l.PopFront()
+				if v != ref[0] {
+					t.Fatal("pop front", v, ref[0])
+				}
+				elems, ref = elems[1:], ref[1:]
+			} else {
+				v, _ := l.PopBack()
+				if v != ref[len(ref)-1] {
+					t.Fatal("pop back", v, ref[len(ref)-1])
+				}
+				elems, ref = elems[:len(elems)-1], ref[:len(ref)-1]
+			}
+		}
+		check(strconv.Itoa(int(op)))
+	}
+
+	for e := range l.Elems() {
+		if e.Value%2 == 0 {
+			l.Remove(e)
+		}
+	}
+	for v := range l.Values() {
+		if v%2 == 0 {
+			t.Fatal("unexpected even value", v)
+		}
+	}
+
+	dl := DListOf(1, 2, 3)
+	j, err := json.Marshal(dl)
+	if lj, _ := json.Marshal(ListOf(1, 2, 3)); err != nil || string(j) != string(lj) {
+		t.Fatal(err, string(j), string(lj))
+	}
+	var jl DList[int]
+	if err = json.Unmarshal(j, &jl); err != nil || !Equal(jl.Slice(), []int{1, 2, 3}) {
+		t.Fatal(err, jl.Slice())
+	}
+	b, err := dl.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bl List[int]
+	if err = bl.UnmarshalBinary(b); err != nil || !Equal(bl.Slice(), []int{1, 2, 3}) {
+		t.Fatal(err, bl.Slice())
+	}
+	if _, ok := dl.Remove(jl.Front()); ok || dl.Len() != 3 {
+		t.Fatal("removed an element of another list")
+	}
+}
+
+func TestListIterModel(t *testing.T) {
+	rnd := xorshift(3)
+	rn := func(n int) int { return int(rnd.Next() % uint64(n)) }
+	for trial := 0; trial < 500; trial++ {
+		// parent is the list the view was made from, the first shared elements of l are parent[start:start+shared]
+		var parent, l List[int]
+		var pref, ref []int
+		start, shared := 0, 0
+		for i := rn(10); i > 0; i-- {
+			parent.Push(i)
+			pref = append(pref, i)
+		}
+		switch kind := rn(4); {
+		case kind == 0 || len(pref) == 0: // plain list
+			l, parent, pref = parent, List[int]{}, nil
+			ref = append(ref, l.Slice()...)
+		case kind == 1: // clip + pushes
+			l, shared = parent.Clip(), len(pref)
+			ref = append(ref, pref...)
+			for i := rn(3); i > 0; i-- {
+				l.Push(100 + i)
+				ref = append(ref, 100+i)
+			}
+		default: // ListAt, optionally pushed to
+			start = rn(len(pref))
+			end := start + rn(len(pref)-start)
+			l, shared = parent.ListAt(start, end), end-start+1
+			ref = append(ref, pref[start:end+1]...)
+			if kind == 3 {
+				l.Push(200)
+				ref = append(ref, 200)
+			}
+		}
+		if !Equal(l.Slice(), ref) || l.Len() != len(ref) {
+			t.Fatal("setup mismatch", trial, l.Slice(), ref)
+		}
+
+		it := l.Iter()
+		pos, cur := 0, -1
+		for op := 0; op < 30; op++ {
+			switch rn(4) {
+			case 0:
+				v, ok := it.Next()
+				if ok != (pos < len(ref)) || ok && v != ref[pos] {
+					t.Fatal("next mismatch", trial, op, v, ok, pos, ref)
+				}
+				if ok {
+					cur, pos = pos, pos+1
+				}
+			case 1:
+				v := 1000 + op
+				if ok := it.TrySet(v); ok != (cur >= 0) {
+					t.Fatal("set mismatch", trial, op, ok, cur)
+				}
+				if cur >= 0 {
+					ref[cur] = v
+					if cur < shared {
+						pref[start+cur] = v
+					}
+				}
+			case 2:
+				if ok := it.TryDelete(); ok != (cur >= 0) {
+					t.Fatal("delete mismatch", trial, op, ok, cur)
+				}
+				if cur >= 0 {
+					ref = Delete(ref, cur, cur+1)
+					pos, cur, shared = pos-1, -1, 0
+				}
+			case 3:
+				v := 2000 + op
+				it.Insert(v)
+				ref = Insert(ref, pos, v)
+				pos, cur, shared = pos+1, -1, 0
+			}
+			if !Equal(l.Slice(), ref) || l.Len() != len(ref) || l.count() != len(ref) {
+				t.Fatal("list mismatch", trial, op, l.Slice(), ref)
+			}
+			if len(ref) > 0 && (l.Head() != ref[0] || l.Tail() != ref[len(ref)-1]) {
+				t.Fatal("head/tail mismatch", trial, op, l.Head(), l.Tail(), ref)
+			}
+			if !Equal(parent.Slice(), pref) {
+				t.Fatal("parent modified", trial, op, parent.Slice(), pref)
+			}
+		}
+
+		// the links must still be valid for pushing to both lists
+		l.Push(-1)
+		ref = append(ref, -1)
+		parent.Push(-2)
+		pref = append(pref, -2)
+		if !Equal(l.Slice(), ref) || !Equal(parent.Slice(), pref) {
+			t.Fatal("push mismatch", trial, l.Slice(), ref, parent.Slice(), pref)
+		}
+		var rev []int
+		for i, v := range l.Backward() {
+			if v != ref[i] {
+				t.Fatal("backward mismatch", trial, i, v)
+			}
+			rev = append(rev, v)
+		}
+		if len(rev) != len(ref) || len(ref) > 1 && rev[0] != ref[len(ref)-1] {
+			t.Fatal("backward mismatch", trial, rev, ref)
+		}
+	}
+}
+
+func TestListClipPush(t *testing.T) {
+	l := ListOf(1, 2, 3)
+	cl := l.Clip()
+	cl.Push(4, 5)
+	l.Push(9)
+	if !Equal(cl.Slice(), []int{1, 2, 3, 4, 5}) || cl.Get(3) != 4 || !Equal(l.Slice(), []int{1, 2, 3, 9}) {
+		t.Fatal("unexpected", cl.Slice(), l.Slice())
+	}
+	ccl := cl.Clip()
+	ccl.Push(6)
+	cl.Push(7)
+	if !Equal(ccl.Slice(), []int{1, 2, 3, 4, 5, 6}) || !Equal(cl.Slice(), []int{1, 2, 3, 4, 5, 7}) {
+		t.Fatal("unexpected", ccl.Slice(), cl.Slice())
+	}
+	if at := cl.ListAt(2, 4); !Equal(at.Slice(), []int{3, 4, 5}) {
+		t.Fatal("unexpected", at.Slice())
+	}
+
+	it := l.Iter()
+	var _ interface { // Set and Delete keep their original signatures
+		Set(int)
+		Delete()
+	} = it
+	it.Set(1)
+	it.Delete()
+	if it.TrySet(1) || it.TryDelete() {
+		t.Fatal("set/delete before next should fail")
+	}
+	for _, ok := it.Next(); ok; _, ok = it.Next() {
+		it.Delete()
+	}
+	if l.Len() != 0 || l.Slice() != nil {
+		t.Fatal("expected an empty list", l.Slice())
+	}
+	l.Push(1)
+	if !Equal(l.Slice(), []int{1}) || l.Tail() != 1 {
+		t.Fatal("unexpected", l.Slice())
+	}
+}
@@ -1,6 +1,9 @@
 package genh
 
-import "sync"
+import (
+	"iter"
+	"sync"
+)
 
 type LList[T any] struct {
 	l   List[T]
@@ -30,6 +33,45 @@ func (l *LList[T]) ForEach(fn func(v T) bool) {
 	l.l.ForEach(fn)
 }
 
+// All returns an iterator over the indices and values, the list is read-locked until the loop is done.
+func (l *LList[T]) All() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		l.mux.RLock()
+		defer l.mux.RUnlock()
+		for i, v := range l.l.All() {
+			if !yield(i, v) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the indices and values from the tail to the head, the list is read-locked until the loop is done.
+func (l *LList[T]) Backward() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		l.mux.RLock()
+		defer l.mux.RUnlock()
+		for i, v := range l.l.Backward() {
+			if !yield(i, v) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the values, the list is read-locked until the loop is done.
+func (l *LList[T]) Values() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		l.mux.RLock()
+		defer l.mux.RUnlock()
+		for v := range l.l.Values() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
 func (l *LList[T]) Clear() {
 	l.mux.Lock()
 	defer l.mux.Unlock()
@@ -21,33 +21,37 @@ type LMap[K comparable, V any] struct {
 
 func (lm *LMap[K, V]) Set(k K, v V) {
 	lm.mux.Lock()
+	lm.set(k, v)
+	lm.mux.Unlock()
+}
+
+// set, swap, deleteGet, etc are the unlocked bodies of the exported methods, they're shared with ShardedMap.
+func (lm *LMap[K, V]) set(k K, v V) {
 	if lm.m == nil {
 		lm.m = make(map[K]V)
 	}
 	lm.m[k] = v
-	lm.mux.Unlock()
 }
 
 func (lm *LMap[K, V]) UpdateKey(k K, fn func(V) V) {
 	lm.mux.Lock()
 	defer lm.mux.Unlock()
-	if lm.m == nil {
-		lm.m = make(map[K]V)
-	}
-	lm.m[k] = fn(lm.m[k])
+	lm.set(k, fn(lm.m[k]))
 }
 
 func (lm *LMap[K, V]) Swap(k K, v V) V {
 	lm.mux.Lock()
-	ov := lm.m[k]
-	if lm.m == nil {
-		lm.m = make(map[K]V)
-	}
-	lm.m[k] = v
+	ov := lm.swap(k, v)
 	lm.mux.Unlock()
 	return ov
 }
 
+func (lm *LMap[K, V]) swap(k K, v V) V {
+	ov := lm.m[k]
+	lm.set(k, v)
+	return ov
+}
+
 func (lm *LMap[K, V]) Delete(k K) {
 	lm.mux.Lock()
 	delete(lm.m, k)
@@ -56,12 +60,18 @@ func (lm *LMap[K, V]) Delete(k K) {
 
 func (lm *LMap[K, V]) DeleteGet(k K) V {
 	lm.mux.Lock()
+	v := lm.deleteGet(k)
+	lm.mux.Unlock()
+	return v
+}
+
+func (lm *LMap[K, V]) deleteGet(k K) V {
 	v := lm.m[k]
 	delete(lm.m, k)
-	lm.mux.Unlock()
 	return v
 }
 
+// KeysSeq returns an iterator over the keys, the map is read-locked until the loop is done.
 func (lm *LMap[K, V]) KeysSeq() (keys iter.Seq[K]) {
 	return func(yield func(K) bool) {
 		lm.mux.RLock()
@@ -74,6 +84,32 @@ func (lm *LMap[K, V]) KeysSeq() (keys iter.Seq[K]) {
 	}
 }
 
+// ValuesSeq returns an iterator over the values, the map is read-locked until the loop is done.
+func (lm *LMap[K, V]) ValuesSeq() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		for _, v := range lm.m {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over all the entries, the map is read-locked until the loop is done.
+func (lm *LMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		for k, v := range lm.m {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
 func (lm *LMap[K, V]) Keys() (keys []K) {
 	lm.mux.RLock()
 	keys = MapKeys(lm.m)
@@ -114,6 +150,62 @@ func (lm *LMap[K, V]) Get(k K) (v V) {
 	return v
 }
 
+func (lm *LMap[K, V]) GetOk(k K) (v V, ok bool) {
+	lm.mux.RLock()
+	v, ok = lm.m[k]
+	lm.mux.RUnlock()
+	return v, ok
+}
+
+// Compute calls fn with the current value of k while the map is locked,
+// k is set to the returned value if keep is true, otherwise it's deleted.
+func (lm *LMap[K, V]) Compute(k K, fn func(old V, exists bool) (nv V, keep bool)) (v V, ok bool) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.compute(k, fn)
+}
+
+func (lm *LMap[K, V]) compute(k K, fn func(old V, exists bool) (nv V, keep bool)) (v V, ok bool) {
+	old, exists := lm.m[k]
+	if v, ok = fn(old, exists); ok {
+		lm.set(k, v)
+	} else if exists {
+		delete(lm.m, k)
+	}
+	return
+}
+
+// LoadOrStore returns the existing value of k if it exists, otherwise it sets it to v and returns v.
+// loaded is true if the value was loaded, false if stored.
+func (lm *LMap[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.loadOrStore(k, v)
+}
+
+func (lm *LMap[K, V]) loadOrStore(k K, v V) (actual V, loaded bool) {
+	if actual, loaded = lm.m[k]; loaded {
+		return
+	}
+	lm.set(k, v)
+	return v, false
+}
+
+// CompareAndSwap sets k to new if it exists and eq(current, old) returns true.
+func (lm *LMap[K, V]) CompareAndSwap(k K, old, new V, eq func(a, b V) bool) (ok bool) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.compareAndSwap(k, old, new, eq)
+}
+
+func (lm *LMap[K, V]) compareAndSwap(k K, old, new V, eq func(a, b V) bool) (ok bool) {
+	cur, exists := lm.m[k]
+	if ok = exists && eq(cur, old); ok {
+		lm.m[k] = new
+	}
+	return
+}
+
 func (lm *LMap[K, V]) MustGet(k K, fn func() V) V {
 	lm.mux.RLock()
 	v, ok := lm.m[k]
@@ -132,17 +224,8 @@ func (lm *LMap[K, V]) MustGet(k K, fn func() V) V {
 	lm.mux.Lock()
 	defer lm.mux.Unlock()
 
-	if v, ok = lm.m[k]; ok { // race check
-		return v
-	}
-
-	if lm.m == nil {
-		lm.m = make(map[K]V)
-	}
-
-	lm.m[k] = nv
-
-	return nv
+	v, _ = lm.loadOrStore(k, nv) // race check
+	return v
 }
 
 func (lm *LMap[K, V]) ForEach(fn func(k K, v V) bool) {
@@ -2,7 +2,13 @@ package genh
 
 import (
 	"encoding/json"
+	"errors"
+	"hash/maphash"
+	"iter"
+	"math"
+	"reflect"
 	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -39,7 +45,7 @@ func TestSLMap(t *testing.T) {
 	for i := 0; i < 10000; i++ {
 		sm.Set(strconv.Itoa(i), S{i})
 	}
-	for _, m := range sm.ms {
+	for _, m := range sm.table().ms {
 		if m.Len() < 250 {
 			t.Fatal("m.Len() < 250", m.Len())
 		}
@@ -69,3 +75,847 @@ func TestSLMap(t *testing.T) {
 		return true
 	})
 }
+
+func TestShardedMap(t *testing.T) {
+	type key struct {
+		A uint64
+		B string
+	}
+	sm := NewShardedMap[uint64, S](4, nil)
+	for i := range uint64(1000) {
+		sm.Set(i, S{int(i)})
+	}
+	for _, m := range sm.table().ms {
+		if m.Len() < 150 {
+			t.Fatal("m.Len() < 150", m.Len())
+		}
+	}
+
+	j, err := json.Marshal(sm)
+	DieIf(t, err)
+	var sm2 ShardedMap[uint64, S]
+	DieIf(t, json.Unmarshal(j, &sm2))
+	if !MapEqual(sm.Clone(), sm2.Clone()) {
+		t.Fatal("sm != sm2")
+	}
+
+	j, err = MarshalMsgpack(sm)
+	DieIf(t, err)
+	var sm3 ShardedMap[uint64, S]
+	DieIf(t, UnmarshalMsgpack(j, &sm3))
+	if !MapEqual(sm.Clone(), sm3.Clone()) {
+		t.Fatal("sm != sm3")
+	}
+
+	var km ShardedMap[key, int]
+	km.Set(key{1, "a"}, 1)
+	km.UpdateKey(key{1, "a"}, func(v int) int { return v + 1 })
+	if v := km.MustGet(key{2, "b"}, func() int { return 3 }); v != 3 {
+		t.Fatal("v != 3", v)
+	}
+	if km.Get(key{1, "a"}) != 2 || km.Len() != 2 {
+		t.Fatal("unexpected", km.Clone())
+	}
+
+	hm := NewShardedMap[int, int](2, func(_ maphash.Seed, k int) uint64 { return uint64(k) })
+	for i := range 10 {
+		hm.Set(i, i)
+	}
+	if hm.table().ms[0].Len() != 5 || hm.table().ms[0].Get(4) != 4 {
+		t.Fatal("unexpected", hm.table().ms[0].Raw())
+	}
+}
+
+func TestShardedMapMarshalConcurrent(t *testing.T) {
+	var (
+		sm   ShardedMap[int, int]
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for i := range 1000 {
+		sm.Set(i, i)
+	}
+	wg.Add(1)
+	go func() { // the length must always match the entries while keys come and go
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				if i%2000 < 1000 {
+					sm.Set(i%1000, i)
+				} else {
+					sm.Delete(i % 1000)
+				}
+			}
+		}
+	}()
+	for range 200 {
+		b, err := sm.MarshalBinary()
+		DieIf(t, err)
+		var dm ShardedMap[int, int]
+		DieIf(t, dm.UnmarshalBinary(b))
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestShardedMapCompute(t *testing.T) {
+	var sm SLMap[int]
+	if v, ok := sm.GetOk("a"); ok || v != 0 {
+		t.Fatal("unexpected", v, ok)
+	}
+	if v, loaded := sm.LoadOrStore("a", 1); loaded || v != 1 {
+		t.Fatal("unexpected", v, loaded)
+	}
+	if v, loaded := sm.LoadOrStore("a", 2); !loaded || v != 1 {
+		t.Fatal("unexpected", v, loaded)
+	}
+	eq := func(a, b int) bool { return a == b }
+	if sm.CompareAndSwap("a", 2, 3, eq) || !sm.CompareAndSwap("a", 1, 3, eq) || sm.CompareAndSwap("b", 0, 3, eq) {
+		t.Fatal("unexpected CompareAndSwap result", sm.Clone())
+	}
+
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sm.Compute("n", func(old int, exists bool) (int, bool) { return old + 1, true })
+		}()
+	}
+	wg.Wait()
+	if v, ok := sm.GetOk("n"); !ok || v != 100 {
+		t.Fatal("expected 100, got", v)
+	}
+	if _, ok := sm.Compute("n", func(old int, _ bool) (int, bool) { return old, false }); ok || sm.Len() != 1 {
+		t.Fatal("expected n to be deleted", sm.Clone())
+	}
+
+	exp := map[string]int{"a": 3, "b": 4, "c": 5}
+	sm.SetMap(exp)
+	got := map[string]int{}
+	for k, v := range sm.All() {
+		got[k] = v
+	}
+	if !MapEqual(exp, got) {
+		t.Fatal("expected", exp, "got", got)
+	}
+	n := 0
+	for _, m := range sm.Raw() {
+		n += len(m)
+	}
+	if n != 3 {
+		t.Fatal("expected 3, got", n)
+	}
+}
+
+func TestShardedMapReshard(t *testing.T) {
+	const N = 10000
+	var (
+		sm   ShardedMap[int, int]
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+
+	wg.Add(1)
+	go func() { // a single writer, so any consistent snapshot must contain all the keys before its max key
+		defer wg.Done()
+		defer close(done)
+		for i := range N {
+			sm.Set(i, i)
+		}
+	}()
+
+	for _, n := range []int{1, 7, 32, 3} {
+		sm.Reshard(n)
+		if sm.NumShards() != n {
+			t.Fatal("expected", n, "shards, got", sm.NumShards())
+		}
+		snap := sm.Snapshot()
+		mx := -1
+		for k, v := range snap {
+			if k != v {
+				t.Fatal("bad value", k, v)
+			}
+			mx = max(mx, k)
+		}
+		if len(snap) != mx+1 {
+			t.Fatal("inconsistent snapshot", len(snap), mx)
+		}
+	}
+	<-done
+	wg.Wait()
+
+	if sm.Len() != N {
+		t.Fatal("expected", N, "got", sm.Len())
+	}
+	for i := range N {
+		if v, ok := sm.GetOk(i); !ok || v != i {
+			t.Fatal("missing", i, v)
+		}
+	}
+
+	raw := sm.Raw()
+	sm.Delete(0)
+	rn := 0
+	for _, m := range raw {
+		rn += len(m)
+	}
+	if rn != N-1 {
+		t.Fatal("Raw didn't return the live shards", rn)
+	}
+	sm.Set(0, 0)
+
+	st := sm.ShardStats()
+	if len(st) != 3 {
+		t.Fatal("expected 3 shards, got", len(st))
+	}
+	n, locks := 0, uint64(0)
+	for _, s := range st {
+		n += s.Len
+		locks += s.RLocks
+	}
+	if n != N || locks < N {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestShardedMapReshardCallbacks(t *testing.T) {
+	// k and k+1 are always in different shards, so the callbacks never lock their own shard.
+	sm := NewShardedMap[int, int](2, func(_ maphash.Seed, k int) uint64 { return uint64(k) })
+	for i := range 100 {
+		sm.Set(i, i)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				sm.Reshard(2 + i%7)
+			}
+		}
+	}()
+	for i := range 10000 {
+		k := i % 99
+		sm.UpdateKey(k, func(v int) int { return sm.Get(k+1) - 1 })
+		sm.Compute(k, func(v int, _ bool) (int, bool) { return sm.Swap(k+1, k+1) - 1, true })
+		sm.MustGet(100+k, func() int { sm.Set(k+1, k+1); return 100 + k })
+	}
+	close(done)
+	wg.Wait()
+	if snap := sm.Snapshot(); len(snap) != 199 {
+		t.Fatal("unexpected", len(snap))
+	}
+	for k, v := range sm.Snapshot() {
+		if k != v {
+			t.Fatal("bad value", k, v)
+		}
+	}
+}
+
+func TestCowMap(t *testing.T) {
+	var cm CowMap[string, int]
+	if v, ok := cm.GetOk("a"); ok || v != 0 || cm.Len() != 0 {
+		t.Fatal("unexpected", v, ok)
+	}
+	cm.Set("a", 1)
+	old := cm.Raw()
+	cm.Set("b", 2)
+	if len(old) != 1 || cm.Len() != 2 {
+		t.Fatal("the old map was modified", old, cm.Raw())
+	}
+	if v, loaded := cm.LoadOrStore("a", 5); !loaded || v != 1 {
+		t.Fatal("unexpected", v, loaded)
+	}
+	eq := func(a, b int) bool { return a == b }
+	if !cm.CompareAndSwap("a", 1, 3, eq) || cm.CompareAndSwap("a", 1, 4, eq) || cm.Get("a") != 3 {
+		t.Fatal("unexpected CompareAndSwap result", cm.Raw())
+	}
+	if v := cm.DeleteGet("b"); v != 2 || cm.Len() != 1 {
+		t.Fatal("unexpected", v, cm.Raw())
+	}
+
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cm.UpdateKey("n", func(v int) int { return v + 1 })
+		}()
+		go func() {
+			defer wg.Done()
+			for range cm.All() {
+			}
+			_ = cm.Get("n")
+		}()
+	}
+	wg.Wait()
+	if cm.Get("n") != 100 {
+		t.Fatal("expected 100, got", cm.Get("n"))
+	}
+
+	exp := map[string]int{"a": 3, "n": 100}
+	j, err := json.Marshal(&cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jm CowMap[string, int]
+	if err = json.Unmarshal(j, &jm); err != nil || !MapEqual(exp, jm.Raw()) {
+		t.Fatal(err, jm.Raw())
+	}
+	b, err := cm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bm CowMap[string, int]
+	if err = bm.UnmarshalBinary(b); err != nil || !MapEqual(exp, bm.Raw()) {
+		t.Fatal(err, bm.Raw())
+	}
+	cm.Clear()
+	if cm.Len() != 0 || len(old) != 1 {
+		t.Fatal("unexpected", cm.Raw(), old)
+	}
+}
+
+func TestMapSeqs(t *testing.T) {
+	exp := map[string]int{"a": 1, "b": 2, "c": 3}
+	sum := func(seq iter.Seq[int]) (n int) {
+		for v := range seq {
+			n += v
+		}
+		return n
+	}
+
+	lm := LMapOf(exp)
+	var sm SLMap[int]
+	sm.SetMap(exp)
+	cm := CowMapOf(exp)
+	if a, b, c := sum(lm.ValuesSeq()), sum(sm.ValuesSeq()), sum(cm.ValuesSeq()); a != 6 || b != 6 || c != 6 {
+		t.Fatal("unexpected sums", a, b, c)
+	}
+	got := map[string]int{}
+	for k, v := range cm.All() {
+		got[k] = v
+		cm.Set("x", 10) // doesn't affect the running loop
+	}
+	if !MapEqual(exp, got) {
+		t.Fatal("expected", exp, "got", got)
+	}
+
+	var mm LMultiMap[string, string, int]
+	mm.Set("a", "x", 1)
+	mm.Set("a", "y", 2)
+	mm.Set("b", "x", 3)
+	n := 0
+	for k1, m := range mm.All() {
+		for k2, v := range m {
+			if mm.Get(k1, k2) != v {
+				t.Fatal("unexpected", k1, k2, v)
+			}
+			n += v
+		}
+	}
+	if n != 6 {
+		t.Fatal("expected 6, got", n)
+	}
+	for _, m := range mm.All() {
+		m["z"] = 10 // the yielded maps are copies
+	}
+	if mm.LenChild("a") != 2 || mm.LenChild("b") != 1 {
+		t.Fatal("All leaked a child map", mm.Get("a", "z"), mm.Get("b", "z"))
+	}
+	n = 0
+	for range mm.KeysSeq() {
+		n++
+	}
+	if n != 2 {
+		t.Fatal("expected 2 keys, got", n)
+	}
+	n = 0
+	for _, v := range mm.AllChild("a") {
+		n += v
+	}
+	if n != 3 {
+		t.Fatal("expected 3, got", n)
+	}
+}
+
+func TestLNestedMap(t *testing.T) {
+	var nm LNestedMap[string, int]
+	nm.Set([]string{"t1", "p1", "r1"}, 1)
+	nm.Set([]string{"t1", "p1", "r2"}, 2)
+	nm.Set([]string{"t1", "p2", "r1"}, 3)
+	nm.Set([]string{"t2", "p1", "r1"}, 4)
+	if nm.Depth() != 3 || nm.Len() != 4 || nm.LenAt("t1") != 3 || nm.LenAt("t1", "p1") != 2 {
+		t.Fatal("unexpected", nm.Depth(), nm.Len(), nm.LenAt("t1"))
+	}
+	if v, ok := nm.GetOk("t1", "p2", "r1"); !ok || v != 3 {
+		t.Fatal("unexpected", v, ok)
+	}
+	if _, ok := nm.GetOk("t1", "p3", "r1"); ok {
+		t.Fatal("unexpected value")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected a panic for a short path")
+			}
+		}()
+		nm.Set([]string{"t1", "p1"}, 5)
+	}()
+
+	sum := 0
+	for path, v := range nm.All("t1") {
+		if len(path) != 3 || path[0] != "t1" {
+			t.Fatal("unexpected path", path)
+		}
+		sum += v
+	}
+	if sum != 6 {
+		t.Fatal("expected 6, got", sum)
+	}
+
+	j, err := json.Marshal(&nm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `{"t1":{"p1":{"r1":1,"r2":2},"p2":{"r1":3}},"t2":{"p1":{"r1":4}}}`; string(j) != exp {
+		t.Fatal("expected", exp, "got", string(j))
+	}
+	jm := NewLNestedMap[string, int](3)
+	if err = json.Unmarshal(j, jm); err != nil || jm.Len() != 4 || jm.Get("t2", "p1", "r1") != 4 {
+		t.Fatal(err, jm.Len())
+	}
+	b, err := nm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := NewLNestedMap[string, int](3)
+	if err = bm.UnmarshalBinary(b); err != nil || bm.Len() != 4 || bm.Get("t1", "p1", "r2") != 2 {
+		t.Fatal(err, bm.Len())
+	}
+
+	// the 2 level encoding matches LMultiMap
+	var mm LMultiMap[string, string, int]
+	mm.Set("a", "x", 1)
+	mm.Set("b", "y", 2)
+	mj, _ := json.Marshal(&mm)
+	nm2 := NewLNestedMap[string, int](2)
+	if err = json.Unmarshal(mj, nm2); err != nil || nm2.Get("b", "y") != 2 {
+		t.Fatal(err, nm2.Len())
+	}
+	if j, _ = json.Marshal(nm2); string(j) != string(mj) {
+		t.Fatal("expected", string(mj), "got", string(j))
+	}
+
+	if n := nm.Delete("t1", "p1", "r1"); n != 1 || !nm.Has("t1", "p1") {
+		t.Fatal("unexpected", n)
+	}
+	if n := nm.Delete("t1", "p1", "r2"); n != 1 || nm.Has("t1", "p1") || !nm.Has("t1") {
+		t.Fatal("expected t1/p1 to be pruned", n)
+	}
+	if n := nm.Delete("t1"); n != 1 || nm.Has("t1") || nm.Len() != 1 {
+		t.Fatal("unexpected", n, nm.Len())
+	}
+	if n := nm.Delete("t2", "p1", "r1"); n != 1 || len(nm.Keys()) != 0 || nm.Len() != 0 || nm.Has() {
+		t.Fatal("expected an empty map", nm.Keys())
+	}
+
+	// an empty path is the root
+	if n := bm.Delete(); n != 4 || bm.Has() || bm.Len() != 0 || len(bm.Keys()) != 0 || bm.LenAt() != 0 {
+		t.Fatal("expected Delete() to clear the map", n, bm.Len(), bm.Keys())
+	}
+	bm.Set([]string{"a", "b", "c"}, 1)
+	if !bm.Has() || bm.Get("a", "b", "c") != 1 || bm.Delete() != 1 || bm.Has("a") {
+		t.Fatal("unexpected", bm.Keys())
+	}
+}
+
+func TestCLMultiMap(t *testing.T) {
+	var mm CLMultiMap[string, string, int]
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k1 := strconv.Itoa(i % 4)
+			for j := range 100 {
+				k2 := strconv.Itoa(j)
+				mm.Set(k1, k2, j)
+				if j%10 == 0 {
+					mm.Delete(k1)
+				}
+				mm.MustGet(k1, "x", func() int { return -1 })
+				_ = mm.LenChild(k1)
+			}
+		}()
+	}
+	wg.Wait()
+	if mm.Len() != 4 {
+		t.Fatal("expected 4 children, got", mm.Len())
+	}
+	for k1, m := range mm.All() {
+		if m["x"] != -1 {
+			t.Fatal("missing x in", k1, m)
+		}
+	}
+	for _, m := range mm.All() {
+		delete(m, "x") // the yielded maps are copies
+	}
+	if v, ok := mm.GetOk("0", "x"); !ok || v != -1 {
+		t.Fatal("All leaked a child map", v, ok)
+	}
+
+	mm.Update("0", func(map[string]int) map[string]int { return nil })
+	if _, ok := mm.GetOk("0", "x"); ok || mm.Len() != 3 {
+		t.Fatal("expected 0 to be deleted", mm.Keys())
+	}
+	var lmm LMultiMap[string, string, int]
+	for _, update := range []func(string, func(map[string]int) map[string]int){mm.Update, lmm.Update} {
+		update("new", func(m map[string]int) map[string]int {
+			if m != nil {
+				t.Fatal("expected a nil map for a missing key", m)
+			}
+			return map[string]int{"x": 1}
+		})
+	}
+	if mm.Get("new", "x") != 1 || lmm.Get("new", "x") != 1 {
+		t.Fatal("expected new to be set")
+	}
+	mm.Delete("new")
+	mm.Clear()
+	mm.Set("a", "x", 1)
+	mm.Set("a", "y", 2)
+	mm.Set("b", "x", 3)
+	if v := mm.DeleteGetChild("a", "y"); v != 2 || mm.LenChild("a") != 1 {
+		t.Fatal("unexpected", v, mm.GetChild("a", false))
+	}
+	j, err := json.Marshal(&mm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `{"a":{"x":1},"b":{"x":3}}`; string(j) != exp {
+		t.Fatal("expected", exp, "got", string(j))
+	}
+	var jm CLMultiMap[string, string, int]
+	if err = json.Unmarshal(j, &jm); err != nil || !reflect.DeepEqual(jm.Clone(), mm.Clone()) {
+		t.Fatal(err, jm.Clone())
+	}
+	b, err := mm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bm CLMultiMap[string, string, int]
+	if err = bm.UnmarshalBinary(b); err != nil || !reflect.DeepEqual(bm.Clone(), mm.Clone()) {
+		t.Fatal(err, bm.Clone())
+	}
+}
+
+func TestSLMultiMap(t *testing.T) {
+	var mm SLMultiMap[int]
+	mm.Set("a", "x", 1)
+	mm.Set("a", "y", 2)
+	mm.Set("b", "x", 3)
+	if v, ok := mm.GetOk("a", "y"); !ok || v != 2 || mm.LenChild("a") != 2 || len(mm.KeysChild("b")) != 1 {
+		t.Fatal("unexpected", v, ok)
+	}
+	if v := mm.DeleteGetChild("a", "y"); v != 2 || mm.LenChild("a") != 1 || mm.Len() != 2 {
+		t.Fatal("unexpected", v, mm.Clone())
+	}
+	mm.Set("b", "y", 4)
+	if mm.Delete("b", "x"); mm.LenChild("b") != 1 || mm.Get("b", "y") != 4 {
+		t.Fatal("expected b.x to be deleted", mm.Clone())
+	}
+	if m := mm.DeleteGetKey("b"); len(m) != 1 || m["y"] != 4 {
+		t.Fatal("unexpected", m)
+	}
+	mm.Set("c", "x", 5)
+	mm.DeleteKey("c")
+	if mm.Len() != 1 || mm.LenChild("b") != 0 || mm.LenChild("c") != 0 {
+		t.Fatal("expected b and c to be deleted", mm.Clone())
+	}
+	j, err := json.Marshal(&mm)
+	if err != nil || string(j) != `{"a":{"x":1}}` {
+		t.Fatal(err, string(j))
+	}
+	var jm SLMultiMap[int]
+	if err = json.Unmarshal(j, &jm); err != nil || jm.Get("a", "x") != 1 {
+		t.Fatal(err, jm.Clone())
+	}
+	n := 0
+	mm.ForEachAll(func(k1, k2 string, v int) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Fatal("expected 1 entry, got", n)
+	}
+}
+
+func TestBiMap(t *testing.T) {
+	var bm BiMap[int, string]
+	bm.Set(1, "a")
+	bm.Set(2, "b")
+	if ok, err := bm.Set(1, "b"); !ok || err != nil || bm.Len() != 1 {
+		t.Fatal("expected the replace policy to remove both old pairs", ok, err, bm.Clone())
+	}
+	if k, ok := bm.GetByValue("b"); !ok || k != 1 || bm.HasValue("a") || bm.HasKey(2) {
+		t.Fatal("unexpected", k, ok, bm.Clone(), bm.Inverse())
+	}
+
+	bm.SetConflictPolicy(BiReject)
+	if ok, err := bm.Set(3, "b"); ok || err != nil || bm.HasKey(3) {
+		t.Fatal("expected the pair to be rejected", ok, err)
+	}
+	bm.SetConflictPolicy(BiError)
+	if ok, err := bm.Set(1, "c"); ok || !errors.Is(err, ErrBiMapConflict) {
+		t.Fatal("expected a conflict error", ok, err)
+	}
+	if ok, err := bm.Set(1, "b"); !ok || err != nil {
+		t.Fatal("setting an existing pair isn't a conflict", ok, err)
+	}
+	if _, err := BiMapOf(map[int]string{1: "a", 2: "a"}); !errors.Is(err, ErrBiMapConflict) {
+		t.Fatal("expected a conflict error", err)
+	}
+
+	lm := NewLBiMap[int, string](0, BiReplace)
+	lm.Set(1, "a")
+	lm.Set(2, "b")
+	j, err := json.Marshal(lm)
+	if err != nil || string(j) != `{"1":"a","2":"b"}` {
+		t.Fatal(err, string(j))
+	}
+	var jm LBiMap[int, string]
+	if err = json.Unmarshal(j, &jm); err != nil || jm.Len() != 2 {
+		t.Fatal(err, jm.Clone())
+	}
+	if k, ok := jm.GetByValue("b"); !ok || k != 2 {
+		t.Fatal("unexpected", k, ok)
+	}
+	jm.SetConflictPolicy(BiError)
+	if err = json.Unmarshal([]byte(`{"3":"c","4":"d","5":"e","6":"a"}`), &jm); !errors.Is(err, ErrBiMapConflict) {
+		t.Fatal("expected a conflict error", err)
+	}
+	if !MapEqual(jm.Clone(), lm.Clone()) || !MapEqual(jm.Inverse(), lm.Inverse()) {
+		t.Fatal("the map was modified by a failed unmarshal", jm.Clone())
+	}
+	b, err := lm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bbm LBiMap[int, string]
+	if err = bbm.UnmarshalBinary(b); err != nil || !MapEqual(bbm.Clone(), lm.Clone()) || !MapEqual(bbm.Inverse(), lm.Inverse()) {
+		t.Fatal(err, bbm.Clone())
+	}
+	cb, _ := MarshalMsgpack(map[int]string{3: "c", 4: "d", 5: "e", 6: "b"})
+	bbm.SetConflictPolicy(BiError)
+	if err = bbm.UnmarshalBinary(cb); !errors.Is(err, ErrBiMapConflict) || !MapEqual(bbm.Clone(), lm.Clone()) || !MapEqual(bbm.Inverse(), lm.Inverse()) {
+		t.Fatal("the map was modified by a failed unmarshal", err, bbm.Clone())
+	}
+	if v, ok := bbm.DeleteByKey(1); !ok || v != "a" || bbm.HasValue("a") {
+		t.Fatal("unexpected", v, ok)
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	var om OrderedMap[string, int]
+	for i, k := range []string{"z", "a", "m", "b"} {
+		om.Set(k, i)
+	}
+	om.Set("z", 10) // keeps its position
+	if keys := om.Keys(); !reflect.DeepEqual(keys, []string{"z", "a", "m", "b"}) {
+		t.Fatal("unexpected order", keys)
+	}
+	om.MoveToBack("z")
+	om.MoveToFront("b")
+	om.Delete("m")
+	if keys := om.Keys(); !reflect.DeepEqual(keys, []string{"b", "a", "z"}) {
+		t.Fatal("unexpected order", keys)
+	}
+	if k, v, ok := om.Back(); !ok || k != "z" || v != 10 {
+		t.Fatal("unexpected", k, v, ok)
+	}
+	var rev []string
+	for k := range om.Backward() {
+		rev = append(rev, k)
+	}
+	if !reflect.DeepEqual(rev, []string{"z", "a", "b"}) {
+		t.Fatal("unexpected order", rev)
+	}
+
+	j, err := json.Marshal(&om)
+	if err != nil || string(j) != `{"b":3,"a":1,"z":10}` {
+		t.Fatal(err, string(j))
+	}
+	var jm LOrderedMap[string, int]
+	if err = json.Unmarshal([]byte(`{"y":1,"x":{"ignored":true},"w":3}`), &jm); err == nil {
+		t.Fatal("expected an error")
+	}
+	jm.Clear()
+	if err = json.Unmarshal(j, &jm); err != nil || !reflect.DeepEqual(jm.Keys(), om.Keys()) {
+		t.Fatal(err, jm.Keys())
+	}
+	b, err := om.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bm OrderedMap[string, int]
+	if err = bm.UnmarshalBinary(b); err != nil || !reflect.DeepEqual(bm.Keys(), om.Keys()) || !reflect.DeepEqual(bm.Values(), om.Values()) {
+		t.Fatal(err, bm.Keys(), bm.Values())
+	}
+
+	var im OrderedMap[int8, string]
+	im.Set(3, "c")
+	im.Set(-1, "a")
+	if j, err = json.Marshal(&im); err != nil || string(j) != `{"3":"c","-1":"a"}` {
+		t.Fatal(err, string(j))
+	}
+	var im2 OrderedMap[int8, string]
+	if err = json.Unmarshal(j, &im2); err != nil || im2.Get(-1) != "a" {
+		t.Fatal(err, im2.Keys())
+	}
+	if err = json.Unmarshal([]byte(`{"300":"x"}`), &im2); err == nil {
+		t.Fatal("expected an overflow error")
+	}
+}
+
+func TestSortedMap(t *testing.T) {
+	var sm SortedMap[int, int]
+	ref := map[int]int{}
+	rnd := xorshift(1)
+	for i := 0; i < 20000; i++ {
+		k := int(rnd.Next() % 2000)
+		if rnd.Next()%3 == 0 {
+			v, ok := sm.DeleteGet(k)
+			if rv, rok := ref[k]; ok != rok || v != rv {
+				t.Fatal("delete mismatch", k, v, ok, rv, rok)
+			}
+			delete(ref, k)
+			continue
+		}
+		_, exists := ref[k]
+		if added := sm.Set(k, i); added == exists {
+			t.Fatal("set mismatch", k, added)
+		}
+		ref[k] = i
+	}
+	keys := MapKeys(ref)
+	Sort(keys)
+	if sm.Len() != len(ref) || !reflect.DeepEqual(sm.Keys(), keys) {
+		t.Fatal("keys mismatch", sm.Len(), len(ref))
+	}
+	for _, k := range keys {
+		if v, ok := sm.GetOk(k); !ok || v != ref[k] {
+			t.Fatal("get mismatch", k, v, ok)
+		}
+	}
+	if k, _, _ := sm.Min(); k != keys[0] {
+		t.Fatal("unexpected min", k)
+	}
+	if k, _, _ := sm.Max(); k != keys[len(keys)-1] {
+		t.Fatal("unexpected max", k)
+	}
+	for q := -1; q <= 2001; q++ {
+		i, found := BinarySearch(keys, q)
+		k, _, ok := sm.Ceil(q)
+		if ok != (i < len(keys)) || ok && k != keys[i] {
+			t.Fatal("ceil mismatch", q, k, ok)
+		}
+		if !found {
+			i--
+		}
+		k, _, ok = sm.Floor(q)
+		if ok != (i >= 0) || ok && k != keys[i] {
+			t.Fatal("floor mismatch", q, k, ok)
+		}
+	}
+	var got []int
+	for k, v := range sm.Ascend(500, 1500) {
+		if v != ref[k] {
+			t.Fatal("value mismatch", k, v)
+		}
+		got = append(got, k)
+	}
+	lo, _ := BinarySearch(keys, 500)
+	hi, _ := BinarySearch(keys, 1500)
+	if !reflect.DeepEqual(got, keys[lo:hi]) {
+		t.Fatal("ascend mismatch", got)
+	}
+	got = got[:0]
+	for k := range sm.Backward() {
+		got = append(got, k)
+	}
+	for i, k := range got {
+		if k != keys[len(keys)-1-i] {
+			t.Fatal("backward mismatch", i, k)
+		}
+	}
+	for _, k := range keys {
+		sm.Delete(k)
+	}
+	if sm.Len() != 0 || len(sm.Keys()) != 0 {
+		t.Fatal("expected an empty map", sm.Len())
+	}
+
+	var fm SortedMap[float64, int]
+	fm.Set(1, 1)
+	fm.Set(2, 2)
+	fm.Set(math.NaN(), 3)
+	if fm.Len() != 3 || fm.Get(1) != 1 || fm.Get(2) != 2 || fm.Get(math.NaN()) != 3 {
+		t.Fatal("NaN overwrote an entry", fm.Keys(), fm.Values())
+	}
+	if k, v, _ := fm.Min(); !math.IsNaN(k) || v != 3 {
+		t.Fatal("expected NaN to sort first", k, v)
+	}
+
+	rm := NewSortedMapFunc[string, int](func(a, b string) int { return -cmp(a, b) })
+	for i, k := range []string{"b", "c", "a"} {
+		rm.Set(k, i)
+	}
+	if keys := rm.Keys(); !reflect.DeepEqual(keys, []string{"c", "b", "a"}) {
+		t.Fatal("unexpected order", keys)
+	}
+
+	var (
+		lm LSortedMap[string, int]
+		wg sync.WaitGroup
+	)
+	for range 4 { // reads of a zero value must not write to it
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, ok := lm.Floor("x"); ok || lm.Has("x") {
+				t.Error("expected an empty map")
+			}
+			lm.Ceil("x")
+		}()
+	}
+	wg.Wait()
+	for i, k := range []string{"z", "a", "m"} {
+		lm.Set(k, i)
+	}
+	lm.Update("a", func(old int, exists bool) int { return old + 10 })
+	j, err := json.Marshal(&lm)
+	if err != nil || string(j) != `{"a":11,"m":2,"z":0}` {
+		t.Fatal(err, string(j))
+	}
+	var jm SortedMap[string, int]
+	if err = json.Unmarshal(j, &jm); err != nil || !reflect.DeepEqual(jm.Keys(), lm.Keys()) {
+		t.Fatal(err, jm.Keys())
+	}
+	b, err := lm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bm LSortedMap[string, int]
+	if err = bm.UnmarshalBinary(b); err != nil || !reflect.DeepEqual(bm.Values(), lm.Values()) {
+		t.Fatal(err, bm.Keys(), bm.Values())
+	}
+}
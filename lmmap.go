@@ -2,6 +2,7 @@ package genh
 
 import (
 	"encoding/json"
+	"iter"
 	"sync"
 )
 
@@ -202,6 +203,45 @@ func (lm *LMultiMap[K1, K2, V]) GetChild(k1 K1, copy bool) map[K2]V {
 	return MapClone(lm.m[k1])
 }
 
+// KeysSeq returns an iterator over the top level keys, the map is read-locked until the loop is done.
+func (lm *LMultiMap[K1, K2, V]) KeysSeq() iter.Seq[K1] {
+	return func(yield func(K1) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		for k1 := range lm.m {
+			if !yield(k1) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the top level keys and copies of their child maps, the map is read-locked until the loop is done.
+func (lm *LMultiMap[K1, K2, V]) All() iter.Seq2[K1, map[K2]V] {
+	return func(yield func(K1, map[K2]V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		for k1, m := range lm.m {
+			if !yield(k1, MapClone(m)) {
+				return
+			}
+		}
+	}
+}
+
+// AllChild returns an iterator over the entries of the k1 child map, the map is read-locked until the loop is done.
+func (lm *LMultiMap[K1, K2, V]) AllChild(k1 K1) iter.Seq2[K2, V] {
+	return func(yield func(K2, V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		for k2, v := range lm.m[k1] {
+			if !yield(k2, v) {
+				return
+			}
+		}
+	}
+}
+
 func (lm *LMultiMap[K1, K2, V]) ForEachAll(fn func(k1 K1, k2 K2, v V) bool) {
 	lm.mux.RLock()
 	defer lm.mux.RUnlock()
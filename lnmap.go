@@ -0,0 +1,411 @@
+package genh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+)
+
+var ErrNestedMapDepth = errors.New("genh: LNestedMap depth isn't set")
+
+// NewLNestedMap returns an LNestedMap where every value is stored under a path of depth keys.
+func NewLNestedMap[K comparable, V any](depth int) *LNestedMap[K, V] {
+	if depth < 1 {
+		panic("genh: LNestedMap depth must be > 0")
+	}
+	return &LNestedMap[K, V]{depth: depth}
+}
+
+// LNestedMap is a locked map of maps with a fixed number of levels, for example tenant -> project -> resource -> value,
+// every value is stored under a full path and empty child maps are removed automatically.
+// LNestedMap[K, V] with a depth of 2 is encoded the same way as LMultiMap[K, K, V].
+// The zero value is ready to use, its depth is set by the first Set.
+type LNestedMap[K comparable, V any] struct {
+	root  nmNode[K, V]
+	depth int
+	ln    int
+	mux   sync.RWMutex
+}
+
+// nmNode is a level of the map, m is nil on the last level, which only holds the value.
+type nmNode[K comparable, V any] struct {
+	m map[K]*nmNode[K, V]
+	v V
+}
+
+func (n *nmNode[K, V]) child(k K, create bool) *nmNode[K, V] {
+	c := n.m[k]
+	if c == nil && create {
+		if n.m == nil {
+			n.m = make(map[K]*nmNode[K, V])
+		}
+		c = &nmNode[K, V]{}
+		n.m[k] = c
+	}
+	return c
+}
+
+// count returns the number of values under n, lvl is the number of levels left.
+func (n *nmNode[K, V]) count(lvl int) (ln int) {
+	if lvl == 0 {
+		return 1
+	}
+	for _, c := range n.m {
+		ln += c.count(lvl - 1)
+	}
+	return ln
+}
+
+func (lm *LNestedMap[K, V]) checkPath(path []K, prefix bool) {
+	if lm.depth == 0 {
+		if prefix {
+			return
+		}
+		panic(ErrNestedMapDepth)
+	}
+	if ln := len(path); ln > lm.depth || (!prefix && ln != lm.depth) {
+		panic(fmt.Sprintf("genh: invalid LNestedMap path length %d, depth is %d", ln, lm.depth))
+	}
+}
+
+// find returns the node at path or nil if it doesn't exist, lm.mux must be held.
+func (lm *LNestedMap[K, V]) find(path []K) *nmNode[K, V] {
+	n := &lm.root
+	for _, k := range path {
+		if n = n.child(k, false); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// Depth returns the number of keys in every path, 0 if it wasn't set yet.
+func (lm *LNestedMap[K, V]) Depth() int {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.depth
+}
+
+// Set sets the value at path, it panics if len(path) doesn't match the depth of the map.
+func (lm *LNestedMap[K, V]) Set(path []K, v V) {
+	lm.Update(path, func(V, bool) V { return v })
+}
+
+// Update sets the value at path to the value returned by fn while the map is locked.
+func (lm *LNestedMap[K, V]) Update(path []K, fn func(old V, exists bool) V) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	if lm.depth == 0 && len(path) > 0 {
+		lm.depth = len(path)
+	}
+	lm.checkPath(path, false)
+	n := &lm.root
+	for _, k := range path[:len(path)-1] {
+		n = n.child(k, true)
+	}
+	k := path[len(path)-1]
+	c := n.child(k, false)
+	if c == nil {
+		lm.ln++
+		c = n.child(k, true)
+		c.v = fn(c.v, false)
+		return
+	}
+	c.v = fn(c.v, true)
+}
+
+func (lm *LNestedMap[K, V]) Get(path ...K) (v V) {
+	v, _ = lm.GetOk(path...)
+	return v
+}
+
+func (lm *LNestedMap[K, V]) GetOk(path ...K) (v V, ok bool) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	if lm.depth == 0 {
+		return
+	}
+	lm.checkPath(path, false)
+	if n := lm.find(path); n != nil {
+		return n.v, true
+	}
+	return
+}
+
+func (lm *LNestedMap[K, V]) MustGet(path []K, fn func() V) V {
+	if v, ok := lm.GetOk(path...); ok {
+		return v
+	}
+	var nv V
+	if fn != nil {
+		// create outside lock in case it's heavy, there's a chance it won't be used
+		nv = fn()
+	}
+	lm.Update(path, func(old V, exists bool) V {
+		if exists { // race check
+			nv = old
+		}
+		return nv
+	})
+	return nv
+}
+
+// Has returns true if there's a value or a child map at path, which can be a prefix,
+// an empty path is the root, so Has() returns true if the map isn't empty.
+func (lm *LNestedMap[K, V]) Has(path ...K) bool {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	lm.checkPath(path, true)
+	if len(path) == 0 {
+		return lm.ln > 0
+	}
+	return lm.depth > 0 && lm.find(path) != nil
+}
+
+// Delete deletes the value at path, or everything under it if it's a prefix,
+// and prunes any parent maps left empty, Delete() clears the map. It returns the number of deleted values.
+func (lm *LNestedMap[K, V]) Delete(path ...K) (n int) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	if lm.depth == 0 {
+		return 0
+	}
+	lm.checkPath(path, true)
+	nodes := make([]*nmNode[K, V], 0, len(path))
+	p := &lm.root
+	for _, k := range path {
+		nodes = append(nodes, p)
+		if p = p.child(k, false); p == nil {
+			return 0
+		}
+	}
+	n = p.count(lm.depth - len(path))
+	lm.ln -= n
+	if len(path) == 0 {
+		lm.root = nmNode[K, V]{}
+		return n
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		delete(nodes[i].m, path[i])
+		if len(nodes[i].m) > 0 {
+			break
+		}
+	}
+	return n
+}
+
+// Keys returns the keys of the child map at prefix, or the top level keys if prefix is empty.
+func (lm *LNestedMap[K, V]) Keys(prefix ...K) []K {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	lm.checkPath(prefix, true)
+	if n := lm.find(prefix); n != nil {
+		return MapKeys(n.m)
+	}
+	return nil
+}
+
+// Len returns the number of values in the map.
+func (lm *LNestedMap[K, V]) Len() int {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.ln
+}
+
+// LenAt returns the number of values under prefix.
+func (lm *LNestedMap[K, V]) LenAt(prefix ...K) int {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	if len(prefix) == 0 {
+		return lm.ln
+	}
+	lm.checkPath(prefix, true)
+	if n := lm.find(prefix); n != nil {
+		return n.count(lm.depth - len(prefix))
+	}
+	return 0
+}
+
+func (lm *LNestedMap[K, V]) Clear() {
+	lm.mux.Lock()
+	lm.root, lm.ln = nmNode[K, V]{}, 0
+	lm.mux.Unlock()
+}
+
+// ForEachAll calls fn for every value under prefix (or the whole map if prefix is empty) while the map is read-locked,
+// path is reused between calls and must be copied if it's kept.
+func (lm *LNestedMap[K, V]) ForEachAll(fn func(path []K, v V) bool, prefix ...K) {
+	for path, v := range lm.All(prefix...) {
+		if !fn(path, v) {
+			return
+		}
+	}
+}
+
+// All returns an iterator over the paths and values under prefix (or the whole map if prefix is empty),
+// the map is read-locked until the loop is done, path is reused between iterations and must be copied if it's kept.
+func (lm *LNestedMap[K, V]) All(prefix ...K) iter.Seq2[[]K, V] {
+	return func(yield func([]K, V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		if lm.depth == 0 {
+			return
+		}
+		lm.checkPath(prefix, true)
+		n := lm.find(prefix)
+		if n == nil {
+			return
+		}
+		path := make([]K, len(prefix), lm.depth)
+		copy(path, prefix)
+		nmWalk(n, path, lm.depth, yield)
+	}
+}
+
+func nmWalk[K comparable, V any](n *nmNode[K, V], path []K, depth int, yield func([]K, V) bool) bool {
+	if len(path) == depth {
+		return yield(path, n.v)
+	}
+	for k, c := range n.m {
+		if !nmWalk(c, append(path, k), depth, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// nmClone returns the nodes under n as nested map[K]any, the last level is a map[K]V.
+func nmClone[K comparable, V any](n *nmNode[K, V], lvl int) any {
+	if lvl == 1 {
+		m := make(map[K]V, len(n.m))
+		for k, c := range n.m {
+			m[k] = c.v
+		}
+		return m
+	}
+	m := make(map[K]any, len(n.m))
+	for k, c := range n.m {
+		m[k] = nmClone(c, lvl-1)
+	}
+	return m
+}
+
+func (lm *LNestedMap[K, V]) MarshalJSON() ([]byte, error) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	if lm.depth == 0 {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(nmClone(&lm.root, lm.depth))
+}
+
+// UnmarshalJSON merges the nested json objects into the map, the depth must be set with NewLNestedMap or a Set first.
+func (lm *LNestedMap[K, V]) UnmarshalJSON(p []byte) error {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	if lm.depth == 0 {
+		return ErrNestedMapDepth
+	}
+	return lm.unmarshalJSON(&lm.root, p, lm.depth)
+}
+
+func (lm *LNestedMap[K, V]) unmarshalJSON(n *nmNode[K, V], p []byte, lvl int) error {
+	if lvl == 0 {
+		return json.Unmarshal(p, &n.v)
+	}
+	var m map[K]json.RawMessage
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		c := n.child(k, false)
+		if c == nil {
+			c = n.child(k, true)
+			if lvl == 1 {
+				lm.ln++
+			}
+		}
+		if err := lm.unmarshalJSON(c, v, lvl-1); err != nil {
+			return err
+		}
+		if lvl > 1 && len(c.m) == 0 {
+			delete(n.m, k)
+		}
+	}
+	return nil
+}
+
+func (lm *LNestedMap[K, V]) MarshalBinary() ([]byte, error) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	var buf bytes.Buffer
+	enc := NewMsgpackEncoder(&buf)
+	defer PutMsgpackEncoder(enc)
+	if err := nmEncode(enc, &lm.root, lm.depth); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func nmEncode[K comparable, V any](enc *MsgpackEncoder, n *nmNode[K, V], lvl int) (err error) {
+	if lvl == 0 {
+		return enc.Encode(n.v)
+	}
+	if err = enc.EncodeMapLen(len(n.m)); err != nil {
+		return err
+	}
+	for k, c := range n.m {
+		if err = enc.Encode(k); err != nil {
+			return err
+		}
+		if err = nmEncode(enc, c, lvl-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalBinary merges the nested msgpack maps into the map, the depth must be set with NewLNestedMap or a Set first.
+func (lm *LNestedMap[K, V]) UnmarshalBinary(p []byte) error {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	if lm.depth == 0 {
+		return ErrNestedMapDepth
+	}
+	dec := NewMsgpackDecoder(bytes.NewReader(p))
+	defer PutMsgpackDecoder(dec)
+	return lm.decode(dec, &lm.root, lm.depth)
+}
+
+func (lm *LNestedMap[K, V]) decode(dec *MsgpackDecoder, n *nmNode[K, V], lvl int) error {
+	if lvl == 0 {
+		return dec.Decode(&n.v)
+	}
+	ln, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	for range ln { // ln is -1 for nil maps
+		var k K
+		if err = dec.Decode(&k); err != nil {
+			return err
+		}
+		c := n.child(k, false)
+		if c == nil {
+			c = n.child(k, true)
+			if lvl == 1 {
+				lm.ln++
+			}
+		}
+		if err = lm.decode(dec, c, lvl-1); err != nil {
+			return err
+		}
+		if lvl > 1 && len(c.m) == 0 {
+			delete(n.m, k)
+		}
+	}
+	return nil
+}
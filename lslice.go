@@ -2,6 +2,7 @@ package genh
 
 import (
 	"encoding/json"
+	"iter"
 	"sync"
 )
 
@@ -129,6 +130,32 @@ func (ls *LSlice[T]) ForEach(fn func(i int, v T) bool) {
 	}
 }
 
+// All returns an iterator over the indices and values, the slice is read-locked until the loop is done.
+func (ls *LSlice[T]) All() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		ls.mux.RLock()
+		defer ls.mux.RUnlock()
+		for i, v := range ls.v {
+			if !yield(i, v) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the values, the slice is read-locked until the loop is done.
+func (ls *LSlice[T]) Values() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		ls.mux.RLock()
+		defer ls.mux.RUnlock()
+		for _, v := range ls.v {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
 func (ls *LSlice[T]) Search(cmpFn func(v T) int) (v T, found bool) {
 	var i int
 	ls.mux.RLock()
@@ -0,0 +1,559 @@
+package genh
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+func NewOrderedMap[K comparable, V any](sz int) *OrderedMap[K, V] {
+	return &OrderedMap[K, V]{m: make(map[K]*omNode[K, V], sz)}
+}
+
+// OrderedMap is a map that keeps its keys in insertion order, including when it's encoded to json or msgpack.
+// Setting an existing key keeps its position, the zero value is ready to use.
+type OrderedMap[K comparable, V any] struct {
+	m    map[K]*omNode[K, V]
+	head *omNode[K, V]
+	tail *omNode[K, V]
+}
+
+type omNode[K comparable, V any] struct {
+	k          K
+	v          V
+	prev, next *omNode[K, V]
+}
+
+func (om *OrderedMap[K, V]) link(n *omNode[K, V], front bool) {
+	if front {
+		if n.next = om.head; om.head != nil {
+			om.head.prev = n
+		} else {
+			om.tail = n
+		}
+		om.head = n
+		return
+	}
+	if n.prev = om.tail; om.tail != nil {
+		om.tail.next = n
+	} else {
+		om.head = n
+	}
+	om.tail = n
+}
+
+func (om *OrderedMap[K, V]) unlink(n *omNode[K, V]) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		om.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		om.tail = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// Set sets k to v, new keys are added to the back of the map.
+func (om *OrderedMap[K, V]) Set(k K, v V) {
+	if n := om.m[k]; n != nil {
+		n.v = v
+		return
+	}
+	if om.m == nil {
+		om.m = make(map[K]*omNode[K, V])
+	}
+	n := &omNode[K, V]{k: k, v: v}
+	om.m[k] = n
+	om.link(n, false)
+}
+
+func (om *OrderedMap[K, V]) Get(k K) (v V) {
+	if n := om.m[k]; n != nil {
+		v = n.v
+	}
+	return
+}
+
+func (om *OrderedMap[K, V]) GetOk(k K) (v V, ok bool) {
+	if n := om.m[k]; n != nil {
+		return n.v, true
+	}
+	return
+}
+
+func (om *OrderedMap[K, V]) Has(k K) bool {
+	return om.m[k] != nil
+}
+
+func (om *OrderedMap[K, V]) Delete(k K) {
+	om.DeleteGet(k)
+}
+
+func (om *OrderedMap[K, V]) DeleteGet(k K) (v V) {
+	if n := om.m[k]; n != nil {
+		om.unlink(n)
+		delete(om.m, k)
+		v = n.v
+	}
+	return
+}
+
+// MoveToFront moves k to the front of the map, it returns false if k doesn't exist.
+func (om *OrderedMap[K, V]) MoveToFront(k K) bool {
+	n := om.m[k]
+	if n == nil {
+		return false
+	}
+	if n != om.head {
+		om.unlink(n)
+		om.link(n, true)
+	}
+	return true
+}
+
+// MoveToBack moves k to the back of the map, it returns false if k doesn't exist.
+func (om *OrderedMap[K, V]) MoveToBack(k K) bool {
+	n := om.m[k]
+	if n == nil {
+		return false
+	}
+	if n != om.tail {
+		om.unlink(n)
+		om.link(n, false)
+	}
+	return true
+}
+
+// Front returns the first entry of the map.
+func (om *OrderedMap[K, V]) Front() (k K, v V, ok bool) {
+	if n := om.head; n != nil {
+		return n.k, n.v, true
+	}
+	return
+}
+
+// Back returns the last entry of the map.
+func (om *OrderedMap[K, V]) Back() (k K, v V, ok bool) {
+	if n := om.tail; n != nil {
+		return n.k, n.v, true
+	}
+	return
+}
+
+func (om *OrderedMap[K, V]) Len() int {
+	return len(om.m)
+}
+
+func (om *OrderedMap[K, V]) Clear() {
+	clear(om.m)
+	om.head, om.tail = nil, nil
+}
+
+// Keys returns the keys in order.
+func (om *OrderedMap[K, V]) Keys() []K {
+	keys := make([]K, 0, len(om.m))
+	for n := om.head; n != nil; n = n.next {
+		keys = append(keys, n.k)
+	}
+	return keys
+}
+
+// Values returns the values in order.
+func (om *OrderedMap[K, V]) Values() []V {
+	values := make([]V, 0, len(om.m))
+	for n := om.head; n != nil; n = n.next {
+		values = append(values, n.v)
+	}
+	return values
+}
+
+// All returns an iterator over the entries in order, the current entry can be deleted during the loop.
+func (om *OrderedMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for n := om.head; n != nil; {
+			next := n.next
+			if !yield(n.k, n.v) {
+				return
+			}
+			n = next
+		}
+	}
+}
+
+// Backward returns an iterator over the entries in reverse order, the current entry can be deleted during the loop.
+func (om *OrderedMap[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for n := om.tail; n != nil; {
+			prev := n.prev
+			if !yield(n.k, n.v) {
+				return
+			}
+			n = prev
+		}
+	}
+}
+
+func (om *OrderedMap[K, V]) ForEach(fn func(k K, v V) bool) {
+	for k, v := range om.All() {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// Clone returns a copy of the map with the same order.
+func (om *OrderedMap[K, V]) Clone() *OrderedMap[K, V] {
+	nm := NewOrderedMap[K, V](len(om.m))
+	for n := om.head; n != nil; n = n.next {
+		nm.Set(n.k, n.v)
+	}
+	return nm
+}
+
+// MarshalJSON encodes the map as a json object with the keys in order,
+// keys are encoded the same way encoding/json encodes map keys.
+func (om *OrderedMap[K, V]) MarshalJSON() ([]byte, error) {
+	return marshalJSONSeq(om.All())
+}
+
+// UnmarshalJSON adds the entries of a json object in the order they appear in p.
+func (om *OrderedMap[K, V]) UnmarshalJSON(p []byte) error {
+	return unmarshalJSONSeq(p, om.Set)
+}
+
+func (om *OrderedMap[K, V]) MarshalBinary() ([]byte, error) {
+	return marshalMsgpackSeq(len(om.m), om.All())
+}
+
+// UnmarshalBinary adds the entries of a msgpack map in the order they appear in p.
+func (om *OrderedMap[K, V]) UnmarshalBinary(p []byte) error {
+	return unmarshalMsgpackSeq(p, om.Set)
+}
+
+// marshalJSONSeq encodes seq as a json object, keeping its order.
+func marshalJSONSeq[K, V any](seq iter.Seq2[K, V]) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for k, v := range seq {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		ks, err := marshalJSONKey(k)
+		if err != nil {
+			return nil, err
+		}
+		kb, _ := json.Marshal(ks)
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// unmarshalJSONSeq decodes a json object and calls set for every entry in the order they appear in p.
+func unmarshalJSONSeq[K, V any](p []byte, set func(k K, v V)) error {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil { // null
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("genh: expected a json object, got %v", tok)
+	}
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+		var (
+			k K
+			v V
+		)
+		if err = unmarshalJSONKey(tok.(string), &k); err != nil {
+			return err
+		}
+		if err = dec.Decode(&v); err != nil {
+			return err
+		}
+		set(k, v)
+	}
+	_, err = dec.Token() // }
+	return err
+}
+
+// marshalMsgpackSeq encodes seq as a msgpack map of ln entries, keeping its order.
+func marshalMsgpackSeq[K, V any](ln int, seq iter.Seq2[K, V]) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewMsgpackEncoder(&buf)
+	defer PutMsgpackEncoder(enc)
+	if err := enc.EncodeMapLen(ln); err != nil {
+		return nil, err
+	}
+	for k, v := range seq {
+		if err := enc.Encode(k); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalMsgpackSeq decodes a msgpack map and calls set for every entry in the order they appear in p.
+func unmarshalMsgpackSeq[K, V any](p []byte, set func(k K, v V)) error {
+	dec := NewMsgpackDecoder(bytes.NewReader(p))
+	defer PutMsgpackDecoder(dec)
+	ln, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	for range ln {
+		var (
+			k K
+			v V
+		)
+		if err = dec.Decode(&k); err != nil {
+			return err
+		}
+		if err = dec.Decode(&v); err != nil {
+			return err
+		}
+		set(k, v)
+	}
+	return nil
+}
+
+// marshalJSONKey converts k to a string the same way encoding/json does for map keys.
+func marshalJSONKey(k any) (string, error) {
+	rv := reflect.ValueOf(k)
+	if rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+	if tm, ok := k.(encoding.TextMarshaler); ok {
+		if rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return "", nil
+		}
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("genh: unsupported json key type %T", k)
+}
+
+// unmarshalJSONKey parses s into k the same way encoding/json does for map keys.
+func unmarshalJSONKey[K any](s string, k *K) error {
+	if tu, ok := any(k).(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(s))
+	}
+	rv := reflect.ValueOf(k).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || rv.OverflowInt(n) {
+			return fmt.Errorf("genh: invalid json key %q for %T", s, *k)
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || rv.OverflowUint(n) {
+			return fmt.Errorf("genh: invalid json key %q for %T", s, *k)
+		}
+		rv.SetUint(n)
+	default:
+		return fmt.Errorf("genh: unsupported json key type %T", *k)
+	}
+	return nil
+}
+
+func NewLOrderedMap[K comparable, V any](sz int) *LOrderedMap[K, V] {
+	return &LOrderedMap[K, V]{om: *NewOrderedMap[K, V](sz)}
+}
+
+// LOrderedMap is a locked OrderedMap, the zero value is ready to use.
+type LOrderedMap[K comparable, V any] struct {
+	om  OrderedMap[K, V]
+	mux sync.RWMutex
+}
+
+func (lm *LOrderedMap[K, V]) Set(k K, v V) {
+	lm.mux.Lock()
+	lm.om.Set(k, v)
+	lm.mux.Unlock()
+}
+
+func (lm *LOrderedMap[K, V]) Get(k K) (v V) {
+	lm.mux.RLock()
+	v = lm.om.Get(k)
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) GetOk(k K) (v V, ok bool) {
+	lm.mux.RLock()
+	v, ok = lm.om.GetOk(k)
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) Has(k K) bool {
+	_, ok := lm.GetOk(k)
+	return ok
+}
+
+func (lm *LOrderedMap[K, V]) Delete(k K) {
+	lm.DeleteGet(k)
+}
+
+func (lm *LOrderedMap[K, V]) DeleteGet(k K) (v V) {
+	lm.mux.Lock()
+	v = lm.om.DeleteGet(k)
+	lm.mux.Unlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) MoveToFront(k K) (ok bool) {
+	lm.mux.Lock()
+	ok = lm.om.MoveToFront(k)
+	lm.mux.Unlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) MoveToBack(k K) (ok bool) {
+	lm.mux.Lock()
+	ok = lm.om.MoveToBack(k)
+	lm.mux.Unlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) Front() (k K, v V, ok bool) {
+	lm.mux.RLock()
+	k, v, ok = lm.om.Front()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) Back() (k K, v V, ok bool) {
+	lm.mux.RLock()
+	k, v, ok = lm.om.Back()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) Len() (v int) {
+	lm.mux.RLock()
+	v = lm.om.Len()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) Clear() {
+	lm.mux.Lock()
+	lm.om.Clear()
+	lm.mux.Unlock()
+}
+
+func (lm *LOrderedMap[K, V]) Keys() (keys []K) {
+	lm.mux.RLock()
+	keys = lm.om.Keys()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) Values() (values []V) {
+	lm.mux.RLock()
+	values = lm.om.Values()
+	lm.mux.RUnlock()
+	return
+}
+
+// All returns an iterator over the entries in order, the map is read-locked until the loop is done.
+func (lm *LOrderedMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		lm.om.All()(yield)
+	}
+}
+
+// Backward returns an iterator over the entries in reverse order, the map is read-locked until the loop is done.
+func (lm *LOrderedMap[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		lm.om.Backward()(yield)
+	}
+}
+
+func (lm *LOrderedMap[K, V]) ForEach(fn func(k K, v V) bool) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	lm.om.ForEach(fn)
+}
+
+// Update calls fn with the underlying OrderedMap while the map is write-locked.
+func (lm *LOrderedMap[K, V]) Update(fn func(om *OrderedMap[K, V])) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	fn(&lm.om)
+}
+
+// Read calls fn with the underlying OrderedMap while the map is read-locked, it must not be modified.
+func (lm *LOrderedMap[K, V]) Read(fn func(om *OrderedMap[K, V])) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	fn(&lm.om)
+}
+
+func (lm *LOrderedMap[K, V]) Clone() (om *OrderedMap[K, V]) {
+	lm.mux.RLock()
+	om = lm.om.Clone()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LOrderedMap[K, V]) MarshalJSON() ([]byte, error) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.om.MarshalJSON()
+}
+
+func (lm *LOrderedMap[K, V]) UnmarshalJSON(p []byte) error {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.om.UnmarshalJSON(p)
+}
+
+func (lm *LOrderedMap[K, V]) MarshalBinary() ([]byte, error) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.om.MarshalBinary()
+}
+
+func (lm *LOrderedMap[K, V]) UnmarshalBinary(p []byte) error {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.om.UnmarshalBinary(p)
+}
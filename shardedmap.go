@@ -0,0 +1,556 @@
+package genh
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/maphash"
+	"iter"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewShardedMap returns a ShardedMap with ln shards (runtime.NumCPU() if ln < 1),
+// hashFn picks the shard of a key, maphash.Comparable is used if it's nil.
+func NewShardedMap[K comparable, V any](ln int, hashFn func(seed maphash.Seed, k K) uint64) *ShardedMap[K, V] {
+	if ln < 1 {
+		ln = runtime.NumCPU()
+	}
+	sm := ShardedMap[K, V]{h: hashFn}
+	sm.init(ln)
+	return &sm
+}
+
+// ShardedMap is a map split into multiple LMaps (NumCPU by default) by the hash of the key,
+// which lowers the lock contention, the zero value is ready to use.
+// The number of shards can be changed at any time with Reshard.
+type ShardedMap[K comparable, V any] struct {
+	t    atomic.Pointer[smTable[K, V]]
+	rmux sync.Mutex // serializes Reshard and the operations that span all the shards (Update, SetMap, Clear)
+	h    func(seed maphash.Seed, k K) uint64
+	s    maphash.Seed
+	o    sync.Once
+}
+
+// smTable is a shard table, old is only set while Reshard is moving the entries of the previous table to ms.
+type smTable[K comparable, V any] struct {
+	ms  []*smShard[K, V]
+	old []*smShard[K, V]
+}
+
+// smShard is a shard of a ShardedMap, it counts its locks and tracks whether Reshard moved its entries.
+type smShard[K comparable, V any] struct {
+	LMap[K, V]
+	ls    lockStats
+	moved bool // guarded by mux, set once Reshard moved the entries to the new shards
+}
+
+func (s *smShard[K, V]) lock() {
+	s.ls.locks.Add(1)
+	if !s.mux.TryLock() {
+		start := time.Now()
+		s.mux.Lock()
+		s.ls.waited(start)
+	}
+}
+
+func (s *smShard[K, V]) rlock() {
+	s.ls.rlocks.Add(1)
+	if !s.mux.TryRLock() {
+		start := time.Now()
+		s.mux.RLock()
+		s.ls.waited(start)
+	}
+}
+
+// lockStats counts how many times a shard was locked and how long it waited for contended locks.
+type lockStats struct {
+	locks     AtomicUint64
+	rlocks    AtomicUint64
+	contended AtomicUint64
+	wait      AtomicInt64
+}
+
+func (ls *lockStats) waited(start time.Time) {
+	ls.contended.Add(1)
+	ls.wait.Add(int64(time.Since(start)))
+}
+
+// ShardStats are the counters of a single shard, they're reset when the map is resharded.
+type ShardStats struct {
+	Len       int           `json:"len"`
+	Locks     uint64        `json:"locks"`
+	RLocks    uint64        `json:"rLocks"`
+	Contended uint64        `json:"contended"`
+	LockWait  time.Duration `json:"lockWait"`
+}
+
+func (lm *ShardedMap[K, V]) hash(k K) uint64 {
+	if lm.h != nil {
+		return lm.h(lm.s, k)
+	}
+	return maphash.Comparable(lm.s, k)
+}
+
+func (lm *ShardedMap[K, V]) table() *smTable[K, V] {
+	lm.initOnce()
+	return lm.t.Load()
+}
+
+// shard calls fn with the shard of k locked, or read-locked if write is false.
+// While a Reshard is running that's the old shard of k until its entries are moved to the new shards.
+func (lm *ShardedMap[K, V]) shard(k K, write bool, fn func(m *LMap[K, V])) {
+	t := lm.table()
+	h := lm.hash(k)
+	for {
+		if t.old != nil && lockShard(t.old[h%uint64(len(t.old))], write, fn) {
+			return
+		}
+		if lockShard(t.ms[h%uint64(len(t.ms))], write, fn) {
+			return
+		}
+		// the shard was moved by a Reshard that started after we loaded t, retry with the new table.
+		t = lm.t.Load()
+	}
+}
+
+// lockShard calls fn with m locked and returns true, unless m was already moved by Reshard.
+func lockShard[K comparable, V any](m *smShard[K, V], write bool, fn func(m *LMap[K, V])) bool {
+	if write {
+		m.lock()
+		defer m.mux.Unlock()
+	} else {
+		m.rlock()
+		defer m.mux.RUnlock()
+	}
+	if m.moved {
+		return false
+	}
+	fn(&m.LMap)
+	return true
+}
+
+// shards returns the shards for the operations that read all of them, it waits for a running Reshard to finish.
+func (lm *ShardedMap[K, V]) shards() []*smShard[K, V] {
+	t := lm.table()
+	if t.old != nil {
+		lm.rmux.Lock()
+		t = lm.t.Load()
+		lm.rmux.Unlock()
+	}
+	return t.ms
+}
+
+func (lm *ShardedMap[K, V]) initOnce() {
+	lm.o.Do(func() {
+		if lm.t.Load() == nil {
+			lm.init(runtime.NumCPU())
+		}
+	})
+}
+
+func (lm *ShardedMap[K, V]) init(sz int) {
+	lm.s = maphash.MakeSeed()
+	lm.t.Store(&smTable[K, V]{ms: newShards[K, V](sz)})
+}
+
+func newShards[K comparable, V any](sz int) []*smShard[K, V] {
+	ms := make([]*smShard[K, V], sz)
+	for i := range ms {
+		ms[i] = &smShard[K, V]{LMap: LMap[K, V]{m: make(map[K]V)}}
+	}
+	return ms
+}
+
+// Reshard changes the number of shards to n (runtime.NumCPU() if n < 1), existing entries are moved to the new shards.
+// The shards are moved one at a time, the keys of a shard wait only while that shard is being moved,
+// The methods that use all the shards (Update, SetMap, Clear, Keys, Values, Clone, Len, Read, Raw, the iterators and
+// MarshalJSON) wait for the whole Reshard, iterators that already started keep using the old shards.
+func (lm *ShardedMap[K, V]) Reshard(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	lm.rmux.Lock()
+	defer lm.rmux.Unlock()
+	t := lm.table()
+	if n == len(t.ms) {
+		return
+	}
+	ms := newShards[K, V](n)
+	lm.t.Store(&smTable[K, V]{ms: ms, old: t.ms})
+	for _, o := range t.ms {
+		lm.move(o, ms)
+	}
+	lm.t.Store(&smTable[K, V]{ms: ms})
+}
+
+// move copies the entries of o to ms and marks it as moved, o isn't cleared so iterators can still use it.
+// It never waits for a new shard while holding o, a callback running on a new shard may be waiting for o.
+func (lm *ShardedMap[K, V]) move(o *smShard[K, V], ms []*smShard[K, V]) {
+	for {
+		o.lock()
+		locked := 0
+		for _, m := range ms {
+			if !m.mux.TryLock() {
+				break
+			}
+			locked++
+		}
+		done := locked == len(ms)
+		if done {
+			for k, v := range o.m {
+				ms[lm.hash(k)%uint64(len(ms))].m[k] = v
+			}
+			o.moved = true
+		}
+		for _, m := range ms[:locked] {
+			m.mux.Unlock()
+		}
+		o.mux.Unlock()
+		if done {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+// NumShards returns the current number of shards.
+func (lm *ShardedMap[K, V]) NumShards() int {
+	return len(lm.table().ms)
+}
+
+// ShardStats returns the size and lock counters of each shard, only the per-key methods and Snapshot are counted.
+// A shard with a lot more locks or lock wait than the others usually means a bad hash function or a few very hot keys.
+func (lm *ShardedMap[K, V]) ShardStats() []ShardStats {
+	ms := lm.table().ms
+	out := make([]ShardStats, 0, len(ms))
+	for _, m := range ms {
+		out = append(out, ShardStats{
+			Len:       m.Len(),
+			Locks:     m.ls.locks.Load(),
+			RLocks:    m.ls.rlocks.Load(),
+			Contended: m.ls.contended.Load(),
+			LockWait:  time.Duration(m.ls.wait.Load()),
+		})
+	}
+	return out
+}
+
+// Snapshot returns a point-in-time copy of the map, all the shards are read-locked while it's being copied.
+func (lm *ShardedMap[K, V]) Snapshot() map[K]V {
+	for {
+		t := lm.table()
+		ms := slices.Concat(t.old, t.ms) // old first, the same order Reshard locks them in
+		ln := 0
+		for _, m := range ms {
+			m.rlock()
+			if !m.moved {
+				ln += len(m.m)
+			}
+		}
+		var out map[K]V
+		if lm.t.Load() == t { // a newer table could have entries we don't have locked
+			out = make(map[K]V, ln)
+			for _, m := range ms {
+				if !m.moved {
+					maps.Copy(out, m.m)
+				}
+			}
+		}
+		for _, m := range ms {
+			m.mux.RUnlock()
+		}
+		if out != nil {
+			return out
+		}
+	}
+}
+
+func (lm *ShardedMap[K, V]) Set(k K, v V) {
+	lm.shard(k, true, func(m *LMap[K, V]) { m.set(k, v) })
+}
+
+func (lm *ShardedMap[K, V]) UpdateKey(k K, fn func(V) V) {
+	lm.shard(k, true, func(m *LMap[K, V]) { m.set(k, fn(m.m[k])) })
+}
+
+func (lm *ShardedMap[K, V]) Swap(k K, v V) (old V) {
+	lm.shard(k, true, func(m *LMap[K, V]) { old = m.swap(k, v) })
+	return old
+}
+
+func (lm *ShardedMap[K, V]) Delete(k K) {
+	lm.shard(k, true, func(m *LMap[K, V]) { delete(m.m, k) })
+}
+
+func (lm *ShardedMap[K, V]) DeleteGet(k K) (v V) {
+	lm.shard(k, true, func(m *LMap[K, V]) { v = m.deleteGet(k) })
+	return v
+}
+
+// Keys returns all the keys, each shard is read separately so it's not a consistent view, use Snapshot for that.
+func (lm *ShardedMap[K, V]) Keys() (keys []K) {
+	ms := lm.shards()
+	ln := 0
+	for _, m := range ms {
+		ln += m.Len()
+	}
+	keys = make([]K, 0, ln)
+	for _, m := range ms {
+		keys = append(keys, m.Keys()...)
+	}
+	return keys
+}
+
+// KeysSeq returns an iterator over the keys, each shard is read-locked while its keys are being iterated.
+func (lm *ShardedMap[K, V]) KeysSeq() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for _, m := range lm.shards() {
+			for key := range m.KeysSeq() {
+				if !yield(key) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ValuesSeq returns an iterator over the values, each shard is read-locked while its values are being iterated.
+func (lm *ShardedMap[K, V]) ValuesSeq() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, m := range lm.shards() {
+			for v := range m.ValuesSeq() {
+				if !yield(v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// All returns an iterator over all the entries, each shard is read-locked while its entries are being iterated.
+func (lm *ShardedMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, m := range lm.shards() {
+			for k, v := range m.All() {
+				if !yield(k, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (lm *ShardedMap[K, V]) Values() (values []V) {
+	ms := lm.shards()
+	ln := 0
+	for _, m := range ms {
+		ln += m.Len()
+	}
+	values = make([]V, 0, ln)
+	for _, m := range ms {
+		values = append(values, m.Values()...)
+	}
+	return values
+}
+
+// Clone returns a copy of the map, each shard is read separately so it's not a consistent view, use Snapshot for that.
+func (lm *ShardedMap[K, V]) Clone() (out map[K]V) {
+	ms := lm.shards()
+	ln := 0
+	for _, m := range ms {
+		ln += m.Len()
+	}
+	out = make(map[K]V, ln)
+	for _, m := range ms {
+		m.ForEach(func(k K, v V) bool {
+			out[k] = v
+			return true
+		})
+	}
+	return out
+}
+
+func (lm *ShardedMap[K, V]) Update(fn func(m map[K]V)) {
+	lm.rmux.Lock()
+	defer lm.rmux.Unlock()
+	for _, m := range lm.table().ms {
+		m.Update(fn)
+	}
+}
+
+func (lm *ShardedMap[K, V]) Read(fn func(m map[K]V)) {
+	for _, m := range lm.shards() {
+		m.Read(fn)
+	}
+}
+
+func (lm *ShardedMap[K, V]) Get(k K) (v V) {
+	lm.shard(k, false, func(m *LMap[K, V]) { v = m.m[k] })
+	return v
+}
+
+func (lm *ShardedMap[K, V]) GetOk(k K) (v V, ok bool) {
+	lm.shard(k, false, func(m *LMap[K, V]) { v, ok = m.m[k] })
+	return v, ok
+}
+
+// Compute calls fn with the current value of k while the key's shard is locked,
+// k is set to the returned value if keep is true, otherwise it's deleted.
+func (lm *ShardedMap[K, V]) Compute(k K, fn func(old V, exists bool) (nv V, keep bool)) (v V, ok bool) {
+	lm.shard(k, true, func(m *LMap[K, V]) { v, ok = m.compute(k, fn) })
+	return v, ok
+}
+
+// LoadOrStore returns the existing value of k if it exists, otherwise it sets it to v and returns v.
+// loaded is true if the value was loaded, false if stored.
+func (lm *ShardedMap[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
+	lm.shard(k, true, func(m *LMap[K, V]) { actual, loaded = m.loadOrStore(k, v) })
+	return actual, loaded
+}
+
+// CompareAndSwap sets k to new if it exists and eq(current, old) returns true.
+func (lm *ShardedMap[K, V]) CompareAndSwap(k K, old, new V, eq func(a, b V) bool) (ok bool) {
+	lm.shard(k, true, func(m *LMap[K, V]) { ok = m.compareAndSwap(k, old, new, eq) })
+	return ok
+}
+
+// MustGet returns the value of k, or sets it to the value returned by fn if it doesn't exist.
+// fn is called without holding any locks, so it may use the map.
+func (lm *ShardedMap[K, V]) MustGet(k K, fn func() V) V {
+	if v, ok := lm.GetOk(k); ok {
+		return v
+	}
+	var nv V
+	if fn != nil {
+		// create outside lock in case it's heavy, there's a chance it won't be used
+		nv = fn()
+	}
+	v, _ := lm.LoadOrStore(k, nv)
+	return v
+}
+
+func (lm *ShardedMap[K, V]) ForEach(fn func(k K, v V) bool) {
+	for k, v := range lm.All() {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+func (lm *ShardedMap[K, V]) SetMap(m map[K]V) {
+	lm.rmux.Lock()
+	defer lm.rmux.Unlock()
+	ms := lm.table().ms
+	for _, m := range ms {
+		m.Clear()
+	}
+	for k, v := range m {
+		ms[lm.hash(k)%uint64(len(ms))].Set(k, v)
+	}
+}
+
+func (lm *ShardedMap[K, V]) Clear() {
+	lm.rmux.Lock()
+	defer lm.rmux.Unlock()
+	for _, m := range lm.table().ms {
+		m.Clear()
+	}
+}
+
+func (lm *ShardedMap[K, V]) Len() (ln int) {
+	for _, m := range lm.shards() {
+		ln += m.Len()
+	}
+	return ln
+}
+
+// Raw returns the underlying map of each shard, they must not be used without external locking
+// and they're stale after a Reshard.
+func (lm *ShardedMap[K, V]) Raw() []map[K]V {
+	ms := lm.shards()
+	out := make([]map[K]V, 0, len(ms))
+	for _, m := range ms {
+		out = append(out, m.Raw())
+	}
+	return out
+}
+
+// MarshalJSON encodes the map as a single json object, keys are only sorted within each shard.
+func (lm *ShardedMap[K, V]) MarshalJSON() (_ []byte, err error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, m := range lm.shards() {
+		var b []byte
+		if b, err = m.MarshalJSON(); err != nil {
+			return
+		}
+		if len(b) < 3 || b[0] != '{' { // empty or null
+			continue
+		}
+		b = b[1 : len(b)-1] // strip the braces, json.Marshal doesn't add whitespace
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (lm *ShardedMap[K, V]) UnmarshalJSON(p []byte) (err error) {
+	var m map[K]V
+	if err = json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		lm.Set(k, v)
+	}
+	return err
+}
+
+// MarshalBinary encodes a Snapshot of the map, so the entry count always matches the entries.
+func (lm *ShardedMap[K, V]) MarshalBinary() (_ []byte, err error) {
+	m := lm.Snapshot()
+	var buf bytes.Buffer
+	enc := NewMsgpackEncoder(&buf)
+	defer PutMsgpackEncoder(enc)
+	if err = enc.EncodeMapLen(len(m)); err != nil {
+		return
+	}
+	for k, v := range m {
+		if err = enc.Encode(k); err != nil {
+			return
+		}
+		if err = enc.Encode(v); err != nil {
+			return
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (lm *ShardedMap[K, V]) UnmarshalBinary(p []byte) error {
+	dec := NewMsgpackDecoder(bytes.NewReader(p))
+	defer PutMsgpackDecoder(dec)
+	ln, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	for range ln {
+		var (
+			k K
+			v V
+		)
+		if err = dec.Decode(&k); err != nil {
+			return err
+		}
+		if err = dec.Decode(&v); err != nil {
+			return err
+		}
+		lm.Set(k, v)
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package genh
 
 import (
+	"encoding/json"
 	"hash/maphash"
+	"iter"
 	"runtime"
 	"sync"
 )
@@ -15,12 +17,16 @@ type SLMultiMap[V any] struct {
 }
 
 func (lm *SLMultiMap[V]) m(k string) *LMultiMap[string, string, V] {
+	lm.initOnce()
+	return lm.ms[maphash.String(lm.s, k)%uint64(len(lm.ms))]
+}
+
+func (lm *SLMultiMap[V]) initOnce() {
 	lm.o.Do(func() {
 		if len(lm.ms) == 0 {
 			lm.init(runtime.NumCPU())
 		}
 	})
-	return lm.ms[maphash.String(lm.s, k)%uint64(len(lm.ms))]
 }
 
 func (lm *SLMultiMap[V]) init(sz int) {
@@ -35,32 +41,215 @@ func (lm *SLMultiMap[V]) Set(k1, k2 string, v V) {
 	lm.m(k1).Set(k1, k2, v)
 }
 
+func (lm *SLMultiMap[V]) SetChild(k1 string, v map[string]V) {
+	lm.m(k1).SetChild(k1, v)
+}
+
+func (lm *SLMultiMap[V]) SetMap(m map[string]map[string]V) {
+	lm.Clear()
+	for k1, v := range m {
+		lm.SetChild(k1, v)
+	}
+}
+
+func (lm *SLMultiMap[V]) Update(k1 string, fn func(m map[string]V) map[string]V) {
+	lm.m(k1).Update(k1, fn)
+}
+
 func (lm *SLMultiMap[V]) Get(k1, k2 string) V {
 	return lm.m(k1).Get(k1, k2)
 }
 
+func (lm *SLMultiMap[V]) GetOk(k1, k2 string) (V, bool) {
+	return lm.m(k1).GetOk(k1, k2)
+}
+
+func (lm *SLMultiMap[V]) GetChild(k1 string, copy bool) map[string]V {
+	return lm.m(k1).GetChild(k1, copy)
+}
+
 func (lm *SLMultiMap[V]) MustGet(k1, k2 string, fn func() V) V {
 	return lm.m(k1).MustGet(k1, k2, fn)
 }
 
+func (lm *SLMultiMap[V]) ReadChild(k1 string, fn func(m map[string]V)) {
+	lm.m(k1).ReadChild(k1, fn)
+}
+
+// Delete deletes k2 from the k1 child map, the same as DeleteChild.
 func (lm *SLMultiMap[V]) Delete(k1, k2 string) {
-	lm.m(k1).Delete(k2)
+	lm.m(k1).DeleteChild(k1, k2)
+}
+
+// DeleteKey deletes the k1 child map.
+func (lm *SLMultiMap[V]) DeleteKey(k1 string) {
+	lm.m(k1).Delete(k1)
+}
+
+// DeleteGetKey deletes the k1 child map and returns it.
+func (lm *SLMultiMap[V]) DeleteGetKey(k1 string) map[string]V {
+	return lm.m(k1).DeleteGet(k1)
+}
+
+func (lm *SLMultiMap[V]) DeleteChild(k1, k2 string) {
+	lm.m(k1).DeleteChild(k1, k2)
+}
+
+func (lm *SLMultiMap[V]) DeleteGetChild(k1, k2 string) V {
+	return lm.m(k1).DeleteGetChild(k1, k2)
+}
+
+func (lm *SLMultiMap[V]) Keys() (keys []string) {
+	lm.initOnce()
+	for _, m := range lm.ms {
+		keys = append(keys, m.Keys()...)
+	}
+	return keys
+}
+
+func (lm *SLMultiMap[V]) KeysChild(k1 string) []string {
+	return lm.m(k1).KeysChild(k1)
+}
+
+func (lm *SLMultiMap[V]) Values(copy bool) (values []map[string]V) {
+	lm.initOnce()
+	for _, m := range lm.ms {
+		values = append(values, m.Values(copy)...)
+	}
+	return values
+}
+
+func (lm *SLMultiMap[V]) ValuesChild(k1 string) []V {
+	return lm.m(k1).ValuesChild(k1)
+}
+
+func (lm *SLMultiMap[V]) Clone() map[string]map[string]V {
+	lm.initOnce()
+	out := make(map[string]map[string]V, lm.Len())
+	for _, m := range lm.ms {
+		m.Read(func(m map[string]map[string]V) {
+			for k1, cm := range m {
+				out[k1] = MapClone(cm)
+			}
+		})
+	}
+	return out
 }
 
 func (lm *SLMultiMap[V]) Clear() {
+	lm.initOnce()
 	for _, m := range lm.ms {
 		m.Clear()
 	}
 }
 
+func (lm *SLMultiMap[V]) ClearChild(k1 string) {
+	lm.m(k1).ClearChild(k1)
+}
+
+// Len returns the number of child maps.
+func (lm *SLMultiMap[V]) Len() (ln int) {
+	lm.initOnce()
+	for _, m := range lm.ms {
+		ln += m.Len()
+	}
+	return ln
+}
+
+func (lm *SLMultiMap[V]) LenChild(k1 string) int {
+	return lm.m(k1).LenChild(k1)
+}
+
+// ForEachAll calls fn for every entry, each shard is read-locked while its entries are being iterated.
+func (lm *SLMultiMap[V]) ForEachAll(fn func(k1, k2 string, v V) bool) {
+	for k1, m := range lm.All() {
+		for k2, v := range m {
+			if !fn(k1, k2, v) {
+				return
+			}
+		}
+	}
+}
+
+// ForEach calls fn for every child map, each shard is locked (write-locked if rw is true) while its children are being iterated.
+func (lm *SLMultiMap[V]) ForEach(fn func(k1 string, m map[string]V) bool, rw bool) {
+	lm.initOnce()
+	for _, m := range lm.ms {
+		cont := true
+		m.ForEach(func(k1 string, m map[string]V) bool {
+			cont = fn(k1, m)
+			return cont
+		}, rw)
+		if !cont {
+			return
+		}
+	}
+}
+
 func (lm *SLMultiMap[V]) ForEachChild(k1 string, fn func(k2 string, v V) bool) {
 	lm.m(k1).ForEachChild(k1, fn)
 }
 
-func (lm *SLMultiMap[V]) Update(k1 string, fn func(m map[string]V) map[string]V) {
-	lm.m(k1).Update(k1, fn)
+// KeysSeq returns an iterator over the top level keys, each shard is read-locked while its keys are being iterated.
+func (lm *SLMultiMap[V]) KeysSeq() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		lm.initOnce()
+		for _, m := range lm.ms {
+			for k1 := range m.KeysSeq() {
+				if !yield(k1) {
+					return
+				}
+			}
+		}
+	}
 }
 
-func (lm *SLMultiMap[V]) SetChild(k1 string, v map[string]V) {
-	lm.m(k1).SetChild(k1, v)
+// All returns an iterator over the top level keys and copies of their child maps,
+// each shard is read-locked while its children are being iterated.
+func (lm *SLMultiMap[V]) All() iter.Seq2[string, map[string]V] {
+	return func(yield func(string, map[string]V) bool) {
+		lm.initOnce()
+		for _, m := range lm.ms {
+			for k1, cm := range m.All() {
+				if !yield(k1, cm) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// AllChild returns an iterator over the entries of the k1 child map, its shard is read-locked until the loop is done.
+func (lm *SLMultiMap[V]) AllChild(k1 string) iter.Seq2[string, V] {
+	return lm.m(k1).AllChild(k1)
+}
+
+func (lm *SLMultiMap[V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lm.Clone())
+}
+
+func (lm *SLMultiMap[V]) UnmarshalJSON(p []byte) error {
+	var m map[string]map[string]V
+	if err := json.Unmarshal(p, &m); err != nil {
+		return err
+	}
+	for k1, v := range m {
+		lm.SetChild(k1, v)
+	}
+	return nil
+}
+
+func (lm *SLMultiMap[V]) MarshalBinary() ([]byte, error) {
+	return MarshalMsgpack(lm.Clone())
+}
+
+func (lm *SLMultiMap[V]) UnmarshalBinary(p []byte) error {
+	var m map[string]map[string]V
+	if err := UnmarshalMsgpack(p, &m); err != nil {
+		return err
+	}
+	for k1, v := range m {
+		lm.SetChild(k1, v)
+	}
+	return nil
 }
@@ -49,6 +49,42 @@ func BenchmarkLMaps(b *testing.B) {
 	})
 }
 
+func BenchmarkReadMostlyMaps(b *testing.B) {
+	var keys [100]string
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	// one write per 1000 reads
+	run := func(b *testing.B, get func(k string) int, set func(k string, v int)) {
+		for i, k := range keys {
+			set(k, i)
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				k := keys[i%len(keys)]
+				if i++; i%1000 == 0 {
+					set(k, i)
+				} else {
+					_ = get(k)
+				}
+			}
+		})
+	}
+	b.Run("LMap", func(b *testing.B) {
+		var m LMap[string, int]
+		run(b, m.Get, m.Set)
+	})
+	b.Run("SLMap", func(b *testing.B) {
+		var m SLMap[int]
+		run(b, m.Get, m.Set)
+	})
+	b.Run("CowMap", func(b *testing.B) {
+		var m CowMap[string, int]
+		run(b, m.Get, m.Set)
+	})
+}
+
 func BenchmarkLMultiMaps(b *testing.B) {
 	N := runtime.NumCPU() * 100
 	var keys [100]string
@@ -89,4 +125,21 @@ func BenchmarkLMultiMaps(b *testing.B) {
 			wg.Wait()
 		}
 	})
+	b.Run("CLMultiMap", func(b *testing.B) {
+		var m CLMultiMap[string, string, int]
+		for b.Loop() {
+			var wg sync.WaitGroup
+			for j := range N {
+				wg.Add(1)
+				j := j % len(keys)
+				go func() {
+					if m.MustGet(keys[j], keys[j], func() int { return j }) != j {
+						panic("bad j")
+					}
+					wg.Done()
+				}()
+			}
+			wg.Wait()
+		}
+	})
 }
@@ -0,0 +1,363 @@
+package genh
+
+import (
+	stdcmp "cmp"
+	"iter"
+	"sync"
+)
+
+// SortedMap is a map that keeps its keys sorted (backed by a B-tree), it supports range queries and ordered iteration.
+// Keys are ordered by cmp.Compare, so NaN keys sort before every other float.
+// It isn't safe for concurrent use, see LSortedMap for that, the zero value is ready to use.
+type SortedMap[K Ordered, V any] struct {
+	t btree[K, V]
+}
+
+// Set sets k to v, it returns true if k is a new key.
+func (sm *SortedMap[K, V]) Set(k K, v V) bool {
+	if sm.t.cmp == nil { // only set by writes, nothing compares keys while the tree is empty
+		sm.t.cmp = stdcmp.Compare[K]
+	}
+	return sm.t.set(k, v)
+}
+
+func (sm *SortedMap[K, V]) Get(k K) (v V) {
+	v, _ = sm.GetOk(k)
+	return
+}
+
+func (sm *SortedMap[K, V]) GetOk(k K) (v V, ok bool) {
+	if it := sm.t.get(k); it != nil {
+		return it.v, true
+	}
+	return
+}
+
+func (sm *SortedMap[K, V]) Has(k K) bool { return sm.t.get(k) != nil }
+
+func (sm *SortedMap[K, V]) Delete(k K) { sm.t.delete(k) }
+
+func (sm *SortedMap[K, V]) DeleteGet(k K) (v V, ok bool) { return sm.t.delete(k) }
+
+func (sm *SortedMap[K, V]) Len() int { return sm.t.ln }
+
+func (sm *SortedMap[K, V]) Clear() { sm.t.root, sm.t.ln = nil, 0 }
+
+// Min returns the entry with the smallest key.
+func (sm *SortedMap[K, V]) Min() (K, V, bool) { return btEntry(sm.t.min()) }
+
+// Max returns the entry with the greatest key.
+func (sm *SortedMap[K, V]) Max() (K, V, bool) { return btEntry(sm.t.max()) }
+
+// Floor returns the entry with the greatest key <= k.
+func (sm *SortedMap[K, V]) Floor(k K) (K, V, bool) { return btEntry(sm.t.floor(k)) }
+
+// Ceil returns the entry with the smallest key >= k.
+func (sm *SortedMap[K, V]) Ceil(k K) (K, V, bool) { return btEntry(sm.t.ceil(k)) }
+
+// Ascend returns an iterator over the entries with from <= key < to in order, the map must not be modified during the loop.
+func (sm *SortedMap[K, V]) Ascend(from, to K) iter.Seq2[K, V] { return sm.t.seq(&from, &to) }
+
+// AscendFrom returns an iterator over the entries with key >= from in order, the map must not be modified during the loop.
+func (sm *SortedMap[K, V]) AscendFrom(from K) iter.Seq2[K, V] { return sm.t.seq(&from, nil) }
+
+// All returns an iterator over all the entries in order, the map must not be modified during the loop.
+func (sm *SortedMap[K, V]) All() iter.Seq2[K, V] { return sm.t.seq(nil, nil) }
+
+// Backward returns an iterator over all the entries in reverse order, the map must not be modified during the loop.
+func (sm *SortedMap[K, V]) Backward() iter.Seq2[K, V] { return sm.t.backward() }
+
+// Keys returns the keys in order.
+func (sm *SortedMap[K, V]) Keys() []K { return sm.t.keys() }
+
+// Values returns the values in key order.
+func (sm *SortedMap[K, V]) Values() []V { return sm.t.values() }
+
+// MarshalJSON encodes the map as a json object with the keys in order.
+func (sm *SortedMap[K, V]) MarshalJSON() ([]byte, error) { return marshalJSONSeq(sm.All()) }
+
+func (sm *SortedMap[K, V]) UnmarshalJSON(p []byte) error {
+	return unmarshalJSONSeq(p, func(k K, v V) { sm.Set(k, v) })
+}
+
+func (sm *SortedMap[K, V]) MarshalBinary() ([]byte, error) {
+	return marshalMsgpackSeq(sm.Len(), sm.All())
+}
+
+func (sm *SortedMap[K, V]) UnmarshalBinary(p []byte) error {
+	return unmarshalMsgpackSeq(p, func(k K, v V) { sm.Set(k, v) })
+}
+
+// NewSortedMapFunc returns a SortedMapFunc ordered by cmp, which returns a negative number if a < b,
+// a positive number if a > b and 0 if they're equal, like BinarySearchFunc.
+func NewSortedMapFunc[K, V any](cmp func(a, b K) int) *SortedMapFunc[K, V] {
+	if cmp == nil {
+		panic("genh: nil cmp func")
+	}
+	return &SortedMapFunc[K, V]{t: btree[K, V]{cmp: cmp}}
+}
+
+// SortedMapFunc is a SortedMap of any key type ordered by a compare func, it must be created with NewSortedMapFunc.
+type SortedMapFunc[K, V any] struct {
+	t btree[K, V]
+}
+
+// Set sets k to v, it returns true if k is a new key.
+func (sm *SortedMapFunc[K, V]) Set(k K, v V) bool { return sm.t.set(k, v) }
+
+func (sm *SortedMapFunc[K, V]) Get(k K) (v V) {
+	v, _ = sm.GetOk(k)
+	return
+}
+
+func (sm *SortedMapFunc[K, V]) GetOk(k K) (v V, ok bool) {
+	if it := sm.t.get(k); it != nil {
+		return it.v, true
+	}
+	return
+}
+
+func (sm *SortedMapFunc[K, V]) Has(k K) bool { return sm.t.get(k) != nil }
+
+func (sm *SortedMapFunc[K, V]) Delete(k K) { sm.t.delete(k) }
+
+func (sm *SortedMapFunc[K, V]) DeleteGet(k K) (v V, ok bool) { return sm.t.delete(k) }
+
+func (sm *SortedMapFunc[K, V]) Len() int { return sm.t.ln }
+
+func (sm *SortedMapFunc[K, V]) Clear() { sm.t.root, sm.t.ln = nil, 0 }
+
+// Min returns the entry with the smallest key.
+func (sm *SortedMapFunc[K, V]) Min() (K, V, bool) { return btEntry(sm.t.min()) }
+
+// Max returns the entry with the greatest key.
+func (sm *SortedMapFunc[K, V]) Max() (K, V, bool) { return btEntry(sm.t.max()) }
+
+// Floor returns the entry with the greatest key <= k.
+func (sm *SortedMapFunc[K, V]) Floor(k K) (K, V, bool) { return btEntry(sm.t.floor(k)) }
+
+// Ceil returns the entry with the smallest key >= k.
+func (sm *SortedMapFunc[K, V]) Ceil(k K) (K, V, bool) { return btEntry(sm.t.ceil(k)) }
+
+// Ascend returns an iterator over the entries with from <= key < to in order, the map must not be modified during the loop.
+func (sm *SortedMapFunc[K, V]) Ascend(from, to K) iter.Seq2[K, V] { return sm.t.seq(&from, &to) }
+
+// AscendFrom returns an iterator over the entries with key >= from in order, the map must not be modified during the loop.
+func (sm *SortedMapFunc[K, V]) AscendFrom(from K) iter.Seq2[K, V] { return sm.t.seq(&from, nil) }
+
+// All returns an iterator over all the entries in order, the map must not be modified during the loop.
+func (sm *SortedMapFunc[K, V]) All() iter.Seq2[K, V] { return sm.t.seq(nil, nil) }
+
+// Backward returns an iterator over all the entries in reverse order, the map must not be modified during the loop.
+func (sm *SortedMapFunc[K, V]) Backward() iter.Seq2[K, V] { return sm.t.backward() }
+
+// Keys returns the keys in order.
+func (sm *SortedMapFunc[K, V]) Keys() []K { return sm.t.keys() }
+
+// Values returns the values in key order.
+func (sm *SortedMapFunc[K, V]) Values() []V { return sm.t.values() }
+
+// MarshalJSON encodes the map as a json object with the keys in order.
+func (sm *SortedMapFunc[K, V]) MarshalJSON() ([]byte, error) { return marshalJSONSeq(sm.All()) }
+
+func (sm *SortedMapFunc[K, V]) UnmarshalJSON(p []byte) error {
+	return unmarshalJSONSeq(p, func(k K, v V) { sm.Set(k, v) })
+}
+
+func (sm *SortedMapFunc[K, V]) MarshalBinary() ([]byte, error) {
+	return marshalMsgpackSeq(sm.Len(), sm.All())
+}
+
+func (sm *SortedMapFunc[K, V]) UnmarshalBinary(p []byte) error {
+	return unmarshalMsgpackSeq(p, func(k K, v V) { sm.Set(k, v) })
+}
+
+// LSortedMap is a locked SortedMap, the zero value is ready to use.
+type LSortedMap[K Ordered, V any] struct {
+	sm  SortedMap[K, V]
+	mux sync.RWMutex
+}
+
+// Set sets k to v, it returns true if k is a new key.
+func (lm *LSortedMap[K, V]) Set(k K, v V) (added bool) {
+	lm.mux.Lock()
+	added = lm.sm.Set(k, v)
+	lm.mux.Unlock()
+	return
+}
+
+// Update calls fn with the current value of k and sets k to the returned value while the map is locked.
+func (lm *LSortedMap[K, V]) Update(k K, fn func(old V, exists bool) V) {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	old, ok := lm.sm.GetOk(k)
+	lm.sm.Set(k, fn(old, ok))
+}
+
+func (lm *LSortedMap[K, V]) Get(k K) (v V) {
+	v, _ = lm.GetOk(k)
+	return
+}
+
+func (lm *LSortedMap[K, V]) GetOk(k K) (v V, ok bool) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.sm.GetOk(k)
+}
+
+func (lm *LSortedMap[K, V]) Has(k K) bool {
+	_, ok := lm.GetOk(k)
+	return ok
+}
+
+func (lm *LSortedMap[K, V]) Delete(k K) {
+	lm.DeleteGet(k)
+}
+
+func (lm *LSortedMap[K, V]) DeleteGet(k K) (v V, ok bool) {
+	lm.mux.Lock()
+	v, ok = lm.sm.DeleteGet(k)
+	lm.mux.Unlock()
+	return
+}
+
+func (lm *LSortedMap[K, V]) Len() (v int) {
+	lm.mux.RLock()
+	v = lm.sm.Len()
+	lm.mux.RUnlock()
+	return
+}
+
+func (lm *LSortedMap[K, V]) Clear() {
+	lm.mux.Lock()
+	lm.sm.Clear()
+	lm.mux.Unlock()
+}
+
+// Min returns the entry with the smallest key.
+func (lm *LSortedMap[K, V]) Min() (k K, v V, ok bool) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return btEntry(lm.sm.t.min())
+}
+
+// Max returns the entry with the greatest key.
+func (lm *LSortedMap[K, V]) Max() (k K, v V, ok bool) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return btEntry(lm.sm.t.max())
+}
+
+// Floor returns the entry with the greatest key <= k.
+func (lm *LSortedMap[K, V]) Floor(k K) (K, V, bool) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.sm.Floor(k)
+}
+
+// Ceil returns the entry with the smallest key >= k.
+func (lm *LSortedMap[K, V]) Ceil(k K) (K, V, bool) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.sm.Ceil(k)
+}
+
+// locked wraps seq so the map is read-locked until the loop is done.
+func (lm *LSortedMap[K, V]) locked(seq func(sm *SortedMap[K, V]) iter.Seq2[K, V]) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		lm.mux.RLock()
+		defer lm.mux.RUnlock()
+		seq(&lm.sm)(yield)
+	}
+}
+
+// Ascend returns an iterator over the entries with from <= key < to in order, the map is read-locked until the loop is done.
+func (lm *LSortedMap[K, V]) Ascend(from, to K) iter.Seq2[K, V] {
+	return lm.locked(func(sm *SortedMap[K, V]) iter.Seq2[K, V] { return sm.Ascend(from, to) })
+}
+
+// AscendFrom returns an iterator over the entries with key >= from in order, the map is read-locked until the loop is done.
+func (lm *LSortedMap[K, V]) AscendFrom(from K) iter.Seq2[K, V] {
+	return lm.locked(func(sm *SortedMap[K, V]) iter.Seq2[K, V] { return sm.AscendFrom(from) })
+}
+
+// All returns an iterator over all the entries in order, the map is read-locked until the loop is done.
+func (lm *LSortedMap[K, V]) All() iter.Seq2[K, V] {
+	return lm.locked((*SortedMap[K, V]).All)
+}
+
+// Backward returns an iterator over all the entries in reverse order, the map is read-locked until the loop is done.
+func (lm *LSortedMap[K, V]) Backward() iter.Seq2[K, V] {
+	return lm.locked((*SortedMap[K, V]).Backward)
+}
+
+func (lm *LSortedMap[K, V]) Keys() []K {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.sm.t.keys()
+}
+
+func (lm *LSortedMap[K, V]) Values() []V {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return lm.sm.t.values()
+}
+
+func (lm *LSortedMap[K, V]) MarshalJSON() ([]byte, error) {
+	return marshalJSONSeq(lm.All())
+}
+
+func (lm *LSortedMap[K, V]) UnmarshalJSON(p []byte) error {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.sm.UnmarshalJSON(p)
+}
+
+func (lm *LSortedMap[K, V]) MarshalBinary() ([]byte, error) {
+	lm.mux.RLock()
+	defer lm.mux.RUnlock()
+	return marshalMsgpackSeq(lm.sm.Len(), lm.sm.t.seq(nil, nil))
+}
+
+func (lm *LSortedMap[K, V]) UnmarshalBinary(p []byte) error {
+	lm.mux.Lock()
+	defer lm.mux.Unlock()
+	return lm.sm.UnmarshalBinary(p)
+}
+
+func btEntry[K, V any](it *btItem[K, V]) (k K, v V, ok bool) {
+	if it != nil {
+		return it.k, it.v, true
+	}
+	return
+}
+
+func (t *btree[K, V]) seq(from, to *K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		t.ascend(t.root, from, to, yield)
+	}
+}
+
+func (t *btree[K, V]) backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		t.descend(t.root, yield)
+	}
+}
+
+func (t *btree[K, V]) keys() []K {
+	keys := make([]K, 0, t.ln)
+	t.ascend(t.root, nil, nil, func(k K, _ V) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return keys
+}
+
+func (t *btree[K, V]) values() []V {
+	values := make([]V, 0, t.ln)
+	t.ascend(t.root, nil, nil, func(_ K, v V) bool {
+		values = append(values, v)
+		return true
+	})
+	return values
+}
@@ -1,52 +1,414 @@
 package genh
 
 import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-type tmEle[V any] struct {
-	v   V
-	t   *time.Timer
-	la  atomic.Int64
-	ttl time.Duration
+// ErrTimedMapClosed is returned by TimedMap.GetOrLoad and TimedMap.GetOrRefresh after the map was closed.
+var ErrTimedMapClosed = errors.New("genh: TimedMap is closed")
+
+// EvictPolicy selects which entry a bounded TimedMap evicts once it goes over its limits.
+// Eviction is approximate like redis: the victim is the best match among 16 sampled entries,
+// so it's only exact while the map holds 16 entries or less.
+type EvictPolicy uint8
+
+const (
+	// EvictLRU evicts the least recently accessed of the sampled entries.
+	EvictLRU EvictPolicy = iota
+	// EvictLFU evicts the least frequently accessed of the sampled entries, ties are broken by access time.
+	EvictLFU
+	// EvictFIFO evicts the oldest inserted of the sampled entries.
+	EvictFIFO
+)
+
+// EvictReason is passed to OnEvict hooks to tell why an entry was removed from a TimedMap.
+type EvictReason uint8
+
+const (
+	// EvictExpired means the entry's ttl expired.
+	EvictExpired EvictReason = iota + 1
+	// EvictIdle means the entry wasn't accessed within its expireIfNotAccessedFor duration.
+	EvictIdle
+	// EvictDeleted means the entry was removed by Delete or DeleteGet.
+	EvictDeleted
+	// EvictReplaced means the entry was overwritten by a new value for the same key.
+	EvictReplaced
+	// EvictCapacity means the entry was evicted to keep the map within its max entries or cost.
+	EvictCapacity
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictIdle:
+		return "idle"
+	case EvictDeleted:
+		return "deleted"
+	case EvictReplaced:
+		return "replaced"
+	case EvictCapacity:
+		return "capacity"
+	default:
+		return "unknown"
+	}
+}
+
+func (r EvictReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// TimedMapEvent is sent on the channels returned by TimedMap.Events.
+type TimedMapEvent[K comparable, V any] struct {
+	Key    K
+	Value  V
+	Reason EvictReason
+}
+
+// tmEvictSamples is the number of entries sampled when picking an eviction victim,
+// maps with fewer entries get exact eviction, larger maps get an approximation (same as redis).
+// Keep the EvictPolicy docs in sync if it changes.
+const tmEvictSamples = 16
+
+type tmEle[K comparable, V any] struct {
+	k    K
+	v    V
+	la   atomic.Int64
+	hits atomic.Uint64
+	ct   int64
+	cost int64
+	ttl  time.Duration
+	err  error // cached loader error, see TimedMap.SetNegativeTTL
+
+	// refresh func and interval for SetUpdateExpireFn entries, nil for entries that expire when their timer fires
+	upd   func()
+	every time.Duration
+
+	// stale-while-revalidate entries, see TimedMap.GetOrRefresh
+	refresh    func(ctx context.Context) (V, error)
+	soft, hard time.Duration
+	ut         atomic.Int64
+	refreshing atomic.Bool
+
+	// guarded by TimedMap.tmux
+	at   int64
+	idx  int
+	dead bool
+
 	sync.RWMutex
 }
 
-func (e *tmEle[V]) expired() bool {
+func newTmEle[K comparable, V any](k K, v V, ttl time.Duration) *tmEle[K, V] {
+	now := time.Now().UnixNano()
+	ele := &tmEle[K, V]{k: k, v: v, ttl: ttl, ct: now, idx: -1}
+	ele.la.Store(now)
+	ele.ut.Store(now)
+	return ele
+}
+
+func (e *tmEle[K, V]) expired() bool {
+	if e.hard > 0 && time.Since(time.Unix(0, e.ut.Load())) > e.hard {
+		return true
+	}
 	if e.ttl < 1 {
 		return false
 	}
 	return time.Since(time.Unix(0, e.la.Load())) > e.ttl
 }
 
+func (e *tmEle[K, V]) stale() bool {
+	return e.soft > 0 && time.Since(time.Unix(0, e.ut.Load())) > e.soft
+}
+
+func (e *tmEle[K, V]) expiredReason() EvictReason {
+	if e.upd != nil {
+		return EvictIdle
+	}
+	return EvictExpired
+}
+
+func (e *tmEle[K, V]) touch() {
+	e.la.Store(time.Now().UnixNano())
+	e.hits.Add(1)
+}
+
+func (e *tmEle[K, V]) value() (v V) {
+	e.RLock()
+	v = e.v
+	e.RUnlock()
+	return
+}
+
+// evictBefore returns true if e should be evicted before o.
+func (e *tmEle[K, V]) evictBefore(o *tmEle[K, V], p EvictPolicy) bool {
+	switch p {
+	case EvictLFU:
+		if eh, oh := e.hits.Load(), o.hits.Load(); eh != oh {
+			return eh < oh
+		}
+	case EvictFIFO:
+		return e.ct < o.ct
+	}
+	return e.la.Load() < o.la.Load()
+}
+
 type TimedMap[K comparable, V any] struct {
-	m LMap[K, *tmEle[V]]
+	m LMap[K, *tmEle[K, V]]
+
+	// guarded by m.mux
+	policy     EvictPolicy
+	maxEntries int
+	maxCost    int64
+	cost       int64
+	costFn     func(k K, v V) int64
+	negTTL     time.Duration
+	refreshErr func(k K, err error)
+
+	lmux  sync.Mutex
+	loads map[K]*tmLoad[V]
+
+	evictFns  LSlice[*tmHook[K, V]]
+	updateFns LMap[K, func() V]
+	closed    atomic.Bool
+	stats     tmStats
+
+	// all the entries' deadlines are kept in a min-heap driven by a single timer,
+	// instead of a runtime timer per entry.
+	tmux    sync.Mutex
+	timers  tmHeap[K, V]
+	timer   *time.Timer
+	timerAt int64
+}
+
+// SetEvictPolicy sets the policy used to evict entries once the map goes over the limits set by
+// SetMaxEntries or SetMaxCost, the default is EvictLRU.
+func (tm *TimedMap[K, V]) SetEvictPolicy(p EvictPolicy) {
+	tm.m.mux.Lock()
+	tm.policy = p
+	tm.m.mux.Unlock()
+}
+
+// SetMaxEntries bounds the number of entries in the map, n < 1 removes the limit.
+// Entries are evicted according to the current EvictPolicy, which is approximate for maps with more than 16 entries,
+// an expired entry found while sampling is always evicted first.
+func (tm *TimedMap[K, V]) SetMaxEntries(n int) {
+	tm.m.mux.Lock()
+	tm.maxEntries = n
+	evicted := tm.evictLocked(nil, nil)
+	tm.m.mux.Unlock()
+	tm.evicted(evicted...)
+}
+
+// SetMaxCost bounds the total cost of all the entries in the map as returned by costFn, n < 1 removes the limit.
+// Entries are evicted the same way as SetMaxEntries.
+// costFn is called while the map is locked, so it must not call back into the map.
+func (tm *TimedMap[K, V]) SetMaxCost(n int64, costFn func(k K, v V) int64) {
+	if n > 0 && costFn == nil {
+		panic("costFn can't be nil")
+	}
+
+	tm.m.mux.Lock()
+	tm.maxCost, tm.costFn, tm.cost = n, costFn, 0
+	for k, ele := range tm.m.m {
+		ele.RLock()
+		ele.cost = tm.eleCost(k, ele.v)
+		ele.RUnlock()
+		tm.cost += ele.cost
+	}
+	evicted := tm.evictLocked(nil, nil)
+	tm.m.mux.Unlock()
+	tm.evicted(evicted...)
+}
+
+// OnEvict registers fn to be called every time an entry is removed from the map, the returned func unregisters it.
+// fn is called without holding any locks, but it blocks the operation that removed the entry.
+func (tm *TimedMap[K, V]) OnEvict(fn func(k K, v V, reason EvictReason)) (remove func()) {
+	return tm.onEvict(fn, nil)
+}
+
+func (tm *TimedMap[K, V]) onEvict(fn func(k K, v V, reason EvictReason), closeFn func()) (remove func()) {
+	h := &tmHook[K, V]{fn: fn, close: closeFn}
+	tm.evictFns.Append(h)
+	return func() {
+		tm.evictFns.Update(func(hs []*tmHook[K, V]) []*tmHook[K, V] {
+			return Filter(hs, func(o *tmHook[K, V]) bool { return o != h }, false)
+		})
+	}
+}
+
+// Events returns a channel that receives an event every time an entry is removed from the map,
+// events are dropped if the channel's buffer is full.
+// cancel must be called once the channel isn't needed anymore, it unregisters and closes the channel,
+// Close closes all the channels returned by Events.
+func (tm *TimedMap[K, V]) Events(bufSize int) (events <-chan TimedMapEvent[K, V], cancel func()) {
+	var (
+		mux    sync.Mutex
+		closed bool
+		ch     = make(chan TimedMapEvent[K, V], bufSize)
+	)
+	closeCh := func() {
+		mux.Lock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+		mux.Unlock()
+	}
+	remove := tm.onEvict(func(k K, v V, reason EvictReason) {
+		mux.Lock()
+		defer mux.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- TimedMapEvent[K, V]{Key: k, Value: v, Reason: reason}:
+		default:
+		}
+	}, closeCh)
+	cancel = func() {
+		remove()
+		closeCh()
+	}
+	return ch, cancel
 }
 
+// SetNegativeTTL makes GetOrLoad cache loader errors for d, so failing keys aren't reloaded on every call,
+// d < 1 (the default) disables caching errors.
+func (tm *TimedMap[K, V]) SetNegativeTTL(d time.Duration) {
+	tm.m.mux.Lock()
+	tm.negTTL = d
+	tm.m.mux.Unlock()
+}
+
+// Set sets k to v, removing it after timeout if it's > 0, it's a no-op after Close.
 func (tm *TimedMap[K, V]) Set(k K, v V, timeout time.Duration) {
-	ele := &tmEle[V]{v: v, ttl: timeout}
-	ele.la.Store(time.Now().UnixNano())
+	if tm.closed.Load() {
+		return
+	}
+	tm.set(k, v, nil, timeout)
+}
+
+func (tm *TimedMap[K, V]) set(k K, v V, err error, timeout time.Duration) {
+	ele := newTmEle(k, v, timeout)
+	ele.err = err
+	tm.setEle(ele, timeout)
+}
+
+func (tm *TimedMap[K, V]) setEle(ele *tmEle[K, V], timeout time.Duration) {
 	if timeout > 0 {
-		ele.t = time.AfterFunc(timeout, func() { tm.deleteEle(k, ele) })
+		tm.schedule(ele, timeout)
 	}
-	tm.m.Set(k, ele)
+	tm.store(ele.k, ele)
 }
 
+// MustGet returns the value of k, or calls vfn and sets it with the given timeout if it doesn't exist.
+// Concurrent calls for the same missing key only call vfn once.
 func (tm *TimedMap[K, V]) MustGet(k K, vfn func() V, timeout time.Duration) (out V) {
-	var ok bool
-	if out, ok = tm.GetOk(k); ok {
+	out, _ = tm.GetOrLoad(context.Background(), k, func(context.Context) (V, error) { return vfn(), nil }, timeout)
+	return
+}
+
+// GetOrLoad returns the value of k, or calls loader and sets its value with the given ttl if it doesn't exist.
+// Concurrent calls for the same missing key share a single loader call.
+// Errors aren't cached unless SetNegativeTTL was used, in which case the error is returned until it expires.
+// If ctx is canceled before the loader returns, GetOrLoad returns ctx.Err(), but the loader keeps running for the other waiters,
+// because of that loader gets a context that isn't canceled with ctx.
+func (tm *TimedMap[K, V]) GetOrLoad(ctx context.Context, k K, loader func(ctx context.Context) (V, error), ttl time.Duration) (v V, err error) {
+	return tm.getOrLoad(ctx, k, loader, ttl, 0)
+}
+
+// GetOrRefresh is like GetOrLoad, but once k's value is older than softTTL, reads return the stale value and trigger
+// a single background refresh using loader, once the value is older than hardTTL it's removed and the next call has to wait for loader.
+// Refresh errors keep the stale value and are reported to the func set by OnRefreshError.
+func (tm *TimedMap[K, V]) GetOrRefresh(ctx context.Context, k K, loader func(ctx context.Context) (V, error), softTTL, hardTTL time.Duration) (v V, err error) {
+	if softTTL < 1 || hardTTL < softTTL {
+		panic("softTTL must be > 0 and <= hardTTL")
+	}
+	return tm.getOrLoad(ctx, k, loader, hardTTL, softTTL)
+}
+
+// OnRefreshError sets the func called when a GetOrRefresh background refresh fails, replacing the previous one.
+func (tm *TimedMap[K, V]) OnRefreshError(fn func(k K, err error)) {
+	tm.m.mux.Lock()
+	tm.refreshErr = fn
+	tm.m.mux.Unlock()
+}
+
+func (tm *TimedMap[K, V]) getOrLoad(ctx context.Context, k K, loader func(ctx context.Context) (V, error), ttl, soft time.Duration) (v V, err error) {
+	if tm.closed.Load() {
+		return v, ErrTimedMapClosed
+	}
+	if ele := tm.get(k); ele != nil {
+		tm.stats.hits.Add(1)
+		return ele.value(), ele.err
+	}
+	tm.stats.misses.Add(1)
+
+	tm.lmux.Lock()
+	if ele := tm.get(k); ele != nil { // a load might've finished while we were waiting for the lock
+		tm.lmux.Unlock()
+		return ele.value(), ele.err
+	}
+	l := tm.loads[k]
+	leader := l == nil
+	if leader {
+		l = &tmLoad[V]{done: make(chan struct{})}
+		if tm.loads == nil {
+			tm.loads = make(map[K]*tmLoad[V])
+		}
+		tm.loads[k] = l
+	}
+	tm.lmux.Unlock()
+
+	if leader {
+		lctx := context.WithoutCancel(ctx)
+		if ctx.Done() == nil { // can't be canceled, no need for a goroutine
+			tm.load(lctx, k, l, loader, ttl, soft)
+		} else {
+			go tm.load(lctx, k, l, loader, ttl, soft)
+		}
+	}
+
+	select {
+	case <-l.done:
+		return l.v, l.err
+	case <-ctx.Done():
+		return v, ctx.Err()
+	}
+}
+
+func (tm *TimedMap[K, V]) load(ctx context.Context, k K, l *tmLoad[V], loader func(ctx context.Context) (V, error), ttl, soft time.Duration) {
+	defer func() {
+		tm.lmux.Lock()
+		delete(tm.loads, k)
+		tm.lmux.Unlock()
+		close(l.done)
+	}()
+
+	start := time.Now()
+	l.v, l.err = loader(ctx)
+	if tm.stats.loaded(start, l.err); l.err == nil {
+		ele := newTmEle(k, l.v, ttl)
+		if soft > 0 {
+			ele.ttl, ele.soft, ele.hard, ele.refresh = 0, soft, ttl, loader
+		}
+		tm.setEle(ele, ttl)
 		return
 	}
-	out = vfn()
-	ele := &tmEle[V]{v: out, ttl: timeout}
-	ele.la.Store(time.Now().UnixNano())
-	if timeout > 0 {
-		ele.t = time.AfterFunc(timeout, func() { tm.deleteEle(k, ele) })
+
+	tm.m.mux.RLock()
+	nttl := tm.negTTL
+	tm.m.mux.RUnlock()
+	if nttl > 0 {
+		tm.set(k, l.v, l.err, nttl)
 	}
-	tm.m.Set(k, ele)
-	return
 }
 
 func (tm *TimedMap[K, V]) SetUpdateFn(k K, vfn func() V, updateEvery time.Duration) {
@@ -57,26 +419,41 @@ func (tm *TimedMap[K, V]) SetUpdateExpireFn(k K, vfn func() V, updateEvery, expi
 	if updateEvery < time.Millisecond {
 		panic("every must be >= time.Millisecond")
 	}
+	tm.setUpdateFn(k, vfn, updateEvery, expireIfNotAccessedFor, nil, 0)
+}
+
+// setUpdateFn creates a SetUpdateExpireFn entry, if v isn't nil it's used as the initial value and vfn is first called after updateEvery,
+// age is how long ago the entry was last accessed.
+func (tm *TimedMap[K, V]) setUpdateFn(k K, vfn func() V, updateEvery, expireIfNotAccessedFor time.Duration, v *V, age time.Duration) {
+	if tm.closed.Load() {
+		return
+	}
 
-	ele := &tmEle[V]{ttl: expireIfNotAccessedFor}
-	ele.la.Store(time.Now().UnixNano())
-	var upfn func()
-	upfn = func() {
+	var zero V
+	ele := newTmEle(k, zero, expireIfNotAccessedFor)
+	ele.every = updateEvery
+	ele.la.Add(-int64(age))
+	ele.upd = func() {
 		if ele.expired() {
-			tm.deleteEle(k, ele)
+			tm.evict(k, ele, EvictIdle)
 			return
 		}
+		start := time.Now()
 		v := vfn()
+		tm.stats.loaded(start, nil)
 		ele.Lock()
-		if ele.t != nil {
-			ele.t.Stop()
-		}
 		ele.v = v
-		ele.t = time.AfterFunc(updateEvery, upfn)
 		ele.Unlock()
+		tm.schedule(ele, updateEvery)
+		tm.updateCost(k, ele, v)
+	}
+	if v != nil {
+		ele.v = *v
+		tm.schedule(ele, updateEvery)
+	} else {
+		ele.upd()
 	}
-	upfn()
-	tm.m.Set(k, ele)
+	tm.store(k, ele)
 }
 
 func (tm *TimedMap[K, V]) Get(k K) (v V) {
@@ -85,53 +462,497 @@ func (tm *TimedMap[K, V]) Get(k K) (v V) {
 }
 
 func (tm *TimedMap[K, V]) GetOk(k K) (v V, ok bool) {
-	ele := tm.m.Get(k)
-	if ok = ele != nil && !ele.expired(); ok {
-		now := time.Now().UnixNano()
-		ele.RLock()
-		defer ele.RUnlock()
-		v = ele.v
-		ele.la.Store(now)
+	ele := tm.get(k)
+	if ok = ele != nil && ele.err == nil; ok {
+		v = ele.value()
+		tm.stats.hits.Add(1)
+	} else {
+		tm.stats.misses.Add(1)
 	}
 	return
 }
 
+// get returns the non-expired element of k and marks it as accessed,
+// triggering a background refresh if it's a stale GetOrRefresh entry.
+func (tm *TimedMap[K, V]) get(k K) *tmEle[K, V] {
+	ele := tm.m.Get(k)
+	if ele == nil || ele.expired() {
+		return nil
+	}
+	ele.touch()
+	if ele.stale() && ele.refreshing.CompareAndSwap(false, true) {
+		go tm.refresh(ele)
+	}
+	return ele
+}
+
+func (tm *TimedMap[K, V]) refresh(ele *tmEle[K, V]) {
+	defer ele.refreshing.Store(false)
+	start := time.Now()
+	v, err := ele.refresh(context.Background())
+	if tm.stats.loaded(start, err); err != nil {
+		tm.m.mux.RLock()
+		fn := tm.refreshErr
+		tm.m.mux.RUnlock()
+		if fn != nil {
+			fn(ele.k, err)
+		}
+		return
+	}
+
+	ele.Lock()
+	ele.v = v
+	ele.Unlock()
+	ele.ut.Store(time.Now().UnixNano())
+	tm.schedule(ele, ele.hard)
+	tm.updateCost(ele.k, ele, v)
+}
+
 func (tm *TimedMap[K, V]) DeleteGet(k K) (v V, ok bool) {
-	ele := tm.m.DeleteGet(k)
+	ele := tm.evict(k, nil, EvictDeleted)
 	if ok = ele != nil && !ele.expired(); ok {
-		ele.RLock()
-		defer ele.RUnlock()
-		v = ele.v
-		if ele.t != nil {
-			ele.t.Stop()
-		}
+		v = ele.value()
 	}
 	return
 }
 
 func (tm *TimedMap[K, V]) Delete(k K) {
-	if ele := tm.m.DeleteGet(k); ele != nil {
-		if ele.t != nil {
-			ele.t.Stop()
+	tm.evict(k, nil, EvictDeleted)
+}
+
+// Clear removes all the entries from the map and stops their timers and refresh funcs.
+func (tm *TimedMap[K, V]) Clear() {
+	tm.m.mux.Lock()
+	old := tm.m.m
+	tm.m.m, tm.cost = nil, 0
+	tm.m.mux.Unlock()
+
+	evicted := make([]tmEvicted[K, V], 0, len(old))
+	for _, ele := range old {
+		evicted = append(evicted, tmEvicted[K, V]{ele, EvictDeleted})
+	}
+	tm.evicted(evicted...)
+}
+
+// Close clears the map, stops all its timers and refresh funcs and closes all the channels returned by Events.
+// Once closed, Set and SetUpdate* are no-ops and GetOrLoad/GetOrRefresh return ErrTimedMapClosed.
+// Loaders and refresh funcs that are already running are allowed to finish, but their results are dropped.
+func (tm *TimedMap[K, V]) Close() error {
+	if !tm.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	tm.Clear()
+
+	tm.tmux.Lock()
+	for _, ele := range tm.timers {
+		ele.dead, ele.idx = true, -1
+	}
+	tm.timers = nil
+	if tm.timer != nil {
+		tm.timer.Stop()
+	}
+	tm.timerAt = 0
+	tm.tmux.Unlock()
+
+	hooks := tm.evictFns.Raw()
+	tm.evictFns.SetSlice(nil)
+	for _, h := range hooks {
+		if h.close != nil {
+			h.close()
 		}
 	}
+	return nil
+}
+
+// pending returns the number of scheduled timers and whether the heap's timer is armed.
+func (tm *TimedMap[K, V]) pending() (n int, armed bool) {
+	tm.tmux.Lock()
+	defer tm.tmux.Unlock()
+	return len(tm.timers), tm.timerAt != 0
+}
+
+// Len returns the number of entries in the map, including expired entries that weren't removed yet.
+func (tm *TimedMap[K, V]) Len() int {
+	return tm.m.Len()
 }
 
 func (tm *TimedMap[K, V]) ForEach(fn func(key K, value V) bool) {
-	keys := tm.m.Keys()
+	for k, v := range tm.All() {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// All returns an iterator over the live entries, it iterates over a snapshot of the keys taken when the loop starts
+// and doesn't hold any locks, so the map can be modified during the loop.
+// Like ForEach, it doesn't count hits or misses.
+func (tm *TimedMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, k := range tm.m.Keys() {
+			if ele := tm.get(k); ele != nil && ele.err == nil {
+				if !yield(k, ele.value()) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// evict removes k if it points to ele (or unconditionally if ele is nil), stops its timer and notifies the OnEvict hooks.
+func (tm *TimedMap[K, V]) evict(k K, ele *tmEle[K, V], reason EvictReason) *tmEle[K, V] {
+	if ele = tm.remove(k, ele); ele != nil {
+		tm.evicted(tmEvicted[K, V]{ele, reason})
+	}
+	return ele
+}
+
+// evicted stops the timers of evicted elements and notifies the OnEvict hooks, must be called without holding any locks.
+func (tm *TimedMap[K, V]) evicted(evs ...tmEvicted[K, V]) {
+	for _, ev := range evs {
+		tm.unschedule(ev.ele)
+		tm.stats.evictions[ev.r].Add(1)
+	}
+
+	hooks := tm.evictFns.Raw()
+	if len(hooks) == 0 {
+		return
+	}
+	for _, ev := range evs {
+		v := ev.ele.value()
+		for _, h := range hooks {
+			h.fn(ev.ele.k, v, ev.r)
+		}
+	}
+}
+
+// remove deletes k from the map if it points to ele, or unconditionally if ele is nil,
+// returns the removed element.
+func (tm *TimedMap[K, V]) remove(k K, ele *tmEle[K, V]) *tmEle[K, V] {
+	tm.m.mux.Lock()
+	defer tm.m.mux.Unlock()
+	old := tm.m.m[k]
+	if old == nil || (ele != nil && old != ele) {
+		return nil
+	}
+	delete(tm.m.m, k)
+	tm.cost -= old.cost
+	return old
+}
+
+// store sets k to ele and evicts entries as needed to stay within the map's limits.
+func (tm *TimedMap[K, V]) store(k K, ele *tmEle[K, V]) {
+	tm.m.mux.Lock()
+	if tm.closed.Load() {
+		tm.m.mux.Unlock()
+		tm.unschedule(ele)
+		return
+	}
+	if tm.m.m == nil {
+		tm.m.m = make(map[K]*tmEle[K, V])
+	}
+	if tm.costFn != nil {
+		ele.RLock()
+		ele.cost = tm.eleCost(k, ele.v)
+		ele.RUnlock()
+	}
+	var evicted []tmEvicted[K, V]
+	if old := tm.m.m[k]; old != nil {
+		tm.cost -= old.cost
+		evicted = append(evicted, tmEvicted[K, V]{old, EvictReplaced})
+	}
+	tm.m.m[k] = ele
+	tm.cost += ele.cost
+	evicted = tm.evictLocked(ele, evicted)
+	tm.m.mux.Unlock()
+	tm.evicted(evicted...)
+}
+
+func (tm *TimedMap[K, V]) updateCost(k K, ele *tmEle[K, V], v V) {
+	tm.m.mux.Lock()
+	if tm.costFn == nil || tm.m.m[k] != ele {
+		tm.m.mux.Unlock()
+		return
+	}
+	cost := tm.eleCost(k, v)
+	tm.cost += cost - ele.cost
+	ele.cost = cost
+	evicted := tm.evictLocked(ele, nil)
+	tm.m.mux.Unlock()
+	tm.evicted(evicted...)
+}
+
+func (tm *TimedMap[K, V]) eleCost(k K, v V) int64 {
+	if tm.costFn == nil {
+		return 0
+	}
+	return tm.costFn(k, v)
+}
+
+func (tm *TimedMap[K, V]) overLimit() bool {
+	return (tm.maxEntries > 0 && len(tm.m.m) > tm.maxEntries) || (tm.maxCost > 0 && tm.cost > tm.maxCost)
+}
+
+// evictLocked removes entries until the map is within its limits, keep is never evicted.
+// must be called with m.mux locked, the returned elements must be passed to evicted after unlocking.
+func (tm *TimedMap[K, V]) evictLocked(keep *tmEle[K, V], evicted []tmEvicted[K, V]) []tmEvicted[K, V] {
+	for tm.overLimit() {
+		var (
+			vk K
+			ve *tmEle[K, V]
+			n  int
+			r  = EvictCapacity
+		)
+		for k, ele := range tm.m.m {
+			if ele == keep {
+				continue
+			}
+			if ele.expired() {
+				vk, ve, r = k, ele, ele.expiredReason()
+				break
+			}
+			if ve == nil || ele.evictBefore(ve, tm.policy) {
+				vk, ve = k, ele
+			}
+			if n++; n == tmEvictSamples {
+				break
+			}
+		}
+
+		if ve == nil { // only keep is left
+			break
+		}
+
+		delete(tm.m.m, vk)
+		tm.cost -= ve.cost
+		evicted = append(evicted, tmEvicted[K, V]{ve, r})
+	}
+	return evicted
+}
+
+// schedule (re)arms ele to fire after d, it's a no-op if ele was already unscheduled.
+func (tm *TimedMap[K, V]) schedule(ele *tmEle[K, V], d time.Duration) {
+	at := time.Now().Add(d).UnixNano()
+	tm.tmux.Lock()
+	defer tm.tmux.Unlock()
+	if ele.dead || tm.closed.Load() {
+		return
+	}
+	ele.at = at
+	if ele.idx < 0 {
+		heap.Push(&tm.timers, ele)
+	} else {
+		heap.Fix(&tm.timers, ele.idx)
+	}
+	tm.armLocked()
+}
+
+// unschedule stops ele's timer and marks it as dead so refresh funcs that are still running won't rearm it.
+func (tm *TimedMap[K, V]) unschedule(ele *tmEle[K, V]) {
+	tm.tmux.Lock()
+	defer tm.tmux.Unlock()
+	ele.dead = true
+	if ele.idx >= 0 {
+		heap.Remove(&tm.timers, ele.idx)
+		tm.armLocked()
+	}
+}
+
+func (tm *TimedMap[K, V]) armLocked() {
+	if len(tm.timers) == 0 {
+		if tm.timer != nil {
+			tm.timer.Stop()
+		}
+		tm.timerAt = 0
+		return
+	}
+
+	next := tm.timers[0].at
+	if next == tm.timerAt {
+		return
+	}
+
+	tm.timerAt = next
+	d := time.Duration(next - time.Now().UnixNano())
+	if tm.timer == nil {
+		tm.timer = time.AfterFunc(d, tm.fire)
+	} else {
+		tm.timer.Reset(d)
+	}
+}
+
+func (tm *TimedMap[K, V]) fire() {
+	var due []*tmEle[K, V]
+	now := time.Now().UnixNano()
+	tm.tmux.Lock()
+	for len(tm.timers) > 0 && tm.timers[0].at <= now {
+		due = append(due, heap.Pop(&tm.timers).(*tmEle[K, V]))
+	}
+	tm.timerAt = 0
+	tm.armLocked()
+	tm.tmux.Unlock()
+
+	for _, ele := range due {
+		if ele.upd != nil {
+			go ele.upd()
+		} else {
+			tm.evict(ele.k, ele, EvictExpired)
+		}
+	}
+}
+
+type tmHeap[K comparable, V any] []*tmEle[K, V]
+
+func (h tmHeap[K, V]) Len() int           { return len(h) }
+func (h tmHeap[K, V]) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h tmHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].idx, h[j].idx = i, j
+}
+
+func (h *tmHeap[K, V]) Push(x any) {
+	ele := x.(*tmEle[K, V])
+	ele.idx = len(*h)
+	*h = append(*h, ele)
+}
+
+func (h *tmHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old) - 1
+	ele := old[n]
+	old[n] = nil
+	ele.idx = -1
+	*h = old[:n]
+	return ele
+}
+
+type tmEvicted[K comparable, V any] struct {
+	ele *tmEle[K, V]
+	r   EvictReason
+}
 
-	for _, k := range keys {
-		if v, ok := tm.GetOk(k); ok {
-			if !fn(k, v) {
-				return
+type tmHook[K comparable, V any] struct {
+	fn    func(k K, v V, reason EvictReason)
+	close func()
+}
+
+type tmLoad[V any] struct {
+	done chan struct{}
+	v    V
+	err  error
+}
+
+// RegisterUpdateFn registers vfn as k's refresh func when restoring SetUpdateFn/SetUpdateExpireFn entries
+// with UnmarshalJSON or UnmarshalBinary, it must be called before restoring.
+// Restored refresh entries that don't have a registered func are restored as plain entries that expire after their update interval.
+func (tm *TimedMap[K, V]) RegisterUpdateFn(k K, vfn func() V) {
+	tm.updateFns.Set(k, vfn)
+}
+
+type tmSnapshot[K comparable, V any] struct {
+	At      int64                   `json:"at"`
+	Entries []tmSnapshotEntry[K, V] `json:"entries"`
+}
+
+type tmSnapshotEntry[K comparable, V any] struct {
+	Key   K             `json:"k"`
+	Value V             `json:"v"`
+	TTL   time.Duration `json:"ttl,omitempty"`   // remaining time before the entry expires
+	Every time.Duration `json:"every,omitempty"` // update interval of SetUpdateExpireFn entries
+	Idle  time.Duration `json:"idle,omitempty"`  // expireIfNotAccessedFor of SetUpdateExpireFn entries
+	Age   time.Duration `json:"age,omitempty"`   // time since the last access of SetUpdateExpireFn entries
+}
+
+// snapshot returns all the non-expired entries with their remaining ttls,
+// GetOrRefresh entries are saved with their remaining soft ttl, since their loaders can't be saved.
+func (tm *TimedMap[K, V]) snapshot() (s tmSnapshot[K, V]) {
+	now := time.Now()
+	s.At = now.UnixNano()
+
+	tm.m.mux.RLock()
+	eles := MapValues(tm.m.m)
+	tm.m.mux.RUnlock()
+
+	s.Entries = make([]tmSnapshotEntry[K, V], 0, len(eles))
+	for _, ele := range eles {
+		if ele.err != nil || ele.expired() {
+			continue
+		}
+
+		e := tmSnapshotEntry[K, V]{Key: ele.k, Value: ele.value()}
+		switch {
+		case ele.upd != nil:
+			e.Every, e.Idle = ele.every, max(ele.ttl, 0)
+			e.Age = now.Sub(time.Unix(0, ele.la.Load()))
+		case ele.soft > 0:
+			if e.TTL = ele.soft - now.Sub(time.Unix(0, ele.ut.Load())); e.TTL < 1 {
+				continue
+			}
+		case ele.ttl > 0:
+			tm.tmux.Lock()
+			at := ele.at
+			tm.tmux.Unlock()
+			if e.TTL = time.Duration(at - s.At); at == 0 || e.TTL < 1 {
+				continue
 			}
 		}
+		s.Entries = append(s.Entries, e)
 	}
+	return
 }
-func (tm *TimedMap[K, V]) deleteEle(k K, ele *tmEle[V]) {
-	tm.m.Update(func(m map[K]*tmEle[V]) {
-		if m[k] == ele {
-			delete(m, k)
+
+// restore adds the snapshot's entries to the map, the time since the snapshot was taken counts against their ttls.
+func (tm *TimedMap[K, V]) restore(s *tmSnapshot[K, V]) {
+	elapsed := time.Duration(time.Now().UnixNano() - s.At)
+	for _, e := range s.Entries {
+		switch {
+		case e.Every > 0:
+			age := e.Age + elapsed
+			if e.Idle > 0 && age >= e.Idle {
+				continue
+			}
+			if vfn := tm.updateFns.Get(e.Key); vfn != nil {
+				tm.setUpdateFn(e.Key, vfn, e.Every, Iff(e.Idle > 0, e.Idle, -1), &e.Value, age)
+			} else {
+				tm.Set(e.Key, e.Value, e.Every)
+			}
+		case e.TTL > 0:
+			if ttl := e.TTL - elapsed; ttl > 0 {
+				tm.Set(e.Key, e.Value, ttl)
+			}
+		default:
+			tm.Set(e.Key, e.Value, 0)
 		}
-	})
+	}
+}
+
+// MarshalJSON saves all the non-expired entries with their remaining ttls, see UnmarshalJSON.
+func (tm *TimedMap[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tm.snapshot())
+}
+
+// UnmarshalJSON restores the entries saved by MarshalJSON, skipping the ones that expired since then, see RegisterUpdateFn.
+func (tm *TimedMap[K, V]) UnmarshalJSON(p []byte) error {
+	var s tmSnapshot[K, V]
+	if err := json.Unmarshal(p, &s); err != nil {
+		return err
+	}
+	tm.restore(&s)
+	return nil
+}
+
+// MarshalBinary is the msgpack version of MarshalJSON.
+func (tm *TimedMap[K, V]) MarshalBinary() ([]byte, error) {
+	return MarshalMsgpack(tm.snapshot())
+}
+
+// UnmarshalBinary is the msgpack version of UnmarshalJSON.
+func (tm *TimedMap[K, V]) UnmarshalBinary(p []byte) error {
+	var s tmSnapshot[K, V]
+	if err := UnmarshalMsgpack(p, &s); err != nil {
+		return err
+	}
+	tm.restore(&s)
+	return nil
 }
@@ -0,0 +1,89 @@
+package genh
+
+import (
+	"expvar"
+	"time"
+)
+
+// TimedMapStats is a point in time snapshot of a TimedMap's counters, see TimedMap.Stats.
+type TimedMapStats struct {
+	Hits       uint64                 `json:"hits"`
+	Misses     uint64                 `json:"misses"`
+	Loads      uint64                 `json:"loads"`
+	LoadErrors uint64                 `json:"loadErrors"`
+	LoadTime   time.Duration          `json:"loadTime"` // total time spent in loaders and refresh funcs
+	Evictions  map[EvictReason]uint64 `json:"evictions"`
+	Size       int                    `json:"size"`
+}
+
+// HitRatio returns hits / (hits + misses).
+func (s TimedMapStats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// AvgLoadTime returns the average time spent in a loader.
+func (s TimedMapStats) AvgLoadTime() time.Duration {
+	if s.Loads > 0 {
+		return s.LoadTime / time.Duration(s.Loads)
+	}
+	return 0
+}
+
+type tmStats struct {
+	hits       AtomicUint64
+	misses     AtomicUint64
+	loads      AtomicUint64
+	loadErrors AtomicUint64
+	loadTime   AtomicInt64
+	evictions  [EvictCapacity + 1]AtomicUint64
+}
+
+func (s *tmStats) loaded(start time.Time, err error) {
+	s.loads.Add(1)
+	s.loadTime.Add(int64(time.Since(start)))
+	if err != nil {
+		s.loadErrors.Add(1)
+	}
+}
+
+// Stats returns a snapshot of the map's counters, the counters are updated without locking,
+// so they might be slightly out of sync with each other under concurrent use.
+func (tm *TimedMap[K, V]) Stats() TimedMapStats {
+	s := &tm.stats
+	out := TimedMapStats{
+		Hits:       s.hits.Load(),
+		Misses:     s.misses.Load(),
+		Loads:      s.loads.Load(),
+		LoadErrors: s.loadErrors.Load(),
+		LoadTime:   time.Duration(s.loadTime.Load()),
+		Evictions:  make(map[EvictReason]uint64, len(s.evictions)-1),
+		Size:       tm.Len(),
+	}
+	for r := EvictExpired; r <= EvictCapacity; r++ {
+		out.Evictions[r] = s.evictions[r].Load()
+	}
+	return out
+}
+
+// ResetStats zeroes all the counters returned by Stats, except for Size.
+func (tm *TimedMap[K, V]) ResetStats() {
+	s := &tm.stats
+	s.hits.Store(0)
+	s.misses.Store(0)
+	s.loads.Store(0)
+	s.loadErrors.Store(0)
+	s.loadTime.Store(0)
+	for i := range s.evictions {
+		s.evictions[i].Store(0)
+	}
+}
+
+// Expvar returns an expvar.Var that exports the map's Stats, for example:
+//
+//	expvar.Publish("usersCache", tm.Expvar())
+func (tm *TimedMap[K, V]) Expvar() expvar.Var {
+	return expvar.Func(func() any { return tm.Stats() })
+}
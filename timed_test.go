@@ -1,8 +1,13 @@
 package genh
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -46,3 +51,463 @@ func TestTimedMap(t *testing.T) {
 		t.Fatalf("goroutine leak: %d > %d", nn, n)
 	}
 }
+
+func TestTimedMapLimits(t *testing.T) {
+	t.Run("LRU", func(t *testing.T) {
+		var tm TimedMap[int, int]
+		tm.SetMaxEntries(3)
+		for i := range 3 {
+			tm.Set(i, i, time.Minute)
+			time.Sleep(time.Millisecond)
+		}
+		tm.Get(0)
+		tm.Set(3, 3, time.Minute)
+		if _, ok := tm.GetOk(1); ok || tm.Len() != 3 {
+			t.Fatal("expected 1 to be evicted", tm.Len())
+		}
+		if _, ok := tm.GetOk(0); !ok {
+			t.Fatal("expected 0 to be kept")
+		}
+	})
+
+	t.Run("LFU", func(t *testing.T) {
+		var tm TimedMap[int, int]
+		tm.SetEvictPolicy(EvictLFU)
+		tm.SetMaxEntries(3)
+		for i := range 3 {
+			tm.Set(i, i, time.Minute)
+		}
+		tm.Get(0)
+		tm.Get(0)
+		tm.Get(1)
+		tm.Set(3, 3, time.Minute)
+		if _, ok := tm.GetOk(2); ok || tm.Len() != 3 {
+			t.Fatal("expected 2 to be evicted", tm.Len())
+		}
+	})
+
+	t.Run("FIFO", func(t *testing.T) {
+		var tm TimedMap[int, int]
+		tm.SetEvictPolicy(EvictFIFO)
+		tm.SetMaxEntries(3)
+		for i := range 3 {
+			tm.Set(i, i, time.Minute)
+			time.Sleep(time.Millisecond)
+		}
+		tm.Get(0)
+		tm.Set(3, 3, time.Minute)
+		if _, ok := tm.GetOk(0); ok || tm.Len() != 3 {
+			t.Fatal("expected 0 to be evicted", tm.Len())
+		}
+	})
+
+	t.Run("Cost", func(t *testing.T) {
+		var tm TimedMap[string, string]
+		tm.SetMaxCost(10, func(k, v string) int64 { return int64(len(v)) })
+		tm.Set("a", "1234", time.Minute)
+		time.Sleep(time.Millisecond)
+		tm.Set("b", "1234", time.Minute)
+		time.Sleep(time.Millisecond)
+		tm.Set("c", "1234", time.Minute)
+		if _, ok := tm.GetOk("a"); ok || tm.Len() != 2 {
+			t.Fatal("expected a to be evicted", tm.Len())
+		}
+		tm.Set("b", "12345678", time.Minute)
+		if tm.Len() != 1 || tm.Get("b") != "12345678" {
+			t.Fatal("expected only b", tm.Len())
+		}
+		tm.SetMaxCost(0, nil)
+		tm.Set("c", "1234", time.Minute)
+		if tm.Len() != 2 {
+			t.Fatal("expected no limit", tm.Len())
+		}
+	})
+}
+
+func BenchmarkTimedMap(b *testing.B) {
+	const N = 1_000_000
+	b.Run("AfterFunc", func(b *testing.B) {
+		// the old engine, a runtime timer per key
+		var m LMap[int, *time.Timer]
+		for b.Loop() {
+			for i := range N {
+				m.Set(i, time.AfterFunc(time.Minute, func() { m.Delete(i) }))
+			}
+			for i := range N {
+				m.DeleteGet(i).Stop()
+			}
+		}
+	})
+	b.Run("TimedMap", func(b *testing.B) {
+		var tm TimedMap[int, int]
+		for b.Loop() {
+			for i := range N {
+				tm.Set(i, i, time.Minute)
+			}
+			for i := range N {
+				tm.Delete(i)
+			}
+		}
+	})
+}
+
+func TestTimedMapOnEvict(t *testing.T) {
+	var tm TimedMap[string, int]
+	var reasons LMap[string, EvictReason]
+	remove := tm.OnEvict(func(k string, v int, reason EvictReason) {
+		reasons.Set(fmt.Sprintf("%s:%d", k, v), reason)
+	})
+	events, cancel := tm.Events(16)
+
+	tm.SetMaxEntries(3)
+	tm.Set("ttl", 1, time.Millisecond*10)
+	tm.Set("del", 1, time.Minute)
+	tm.Set("rep", 1, time.Minute)
+	tm.Set("rep", 2, time.Minute)
+	tm.Delete("del")
+	tm.SetUpdateExpireFn("idle", func() int { return 1 }, time.Millisecond*10, time.Millisecond*20)
+	time.Sleep(time.Millisecond * 100)
+	tm.Set("cap1", 1, time.Minute)
+	tm.Set("cap2", 1, time.Minute)
+	tm.Set("cap3", 1, time.Minute)
+
+	exp := map[string]EvictReason{
+		"ttl:1":  EvictExpired,
+		"del:1":  EvictDeleted,
+		"rep:1":  EvictReplaced,
+		"idle:1": EvictIdle,
+		"rep:2":  EvictCapacity,
+	}
+	if got := reasons.Clone(); !MapEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	cancel()
+	n := 0
+	for ev := range events {
+		if r := exp[fmt.Sprintf("%s:%d", ev.Key, ev.Value)]; r != ev.Reason {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+		n++
+	}
+	if n != len(exp) {
+		t.Fatalf("expected %d events, got %d", len(exp), n)
+	}
+
+	remove()
+	tm.Delete("cap1")
+	if _, ok := reasons.Clone()["cap1:1"]; ok {
+		t.Fatal("hook wasn't removed")
+	}
+}
+
+func TestTimedMapGetOrLoad(t *testing.T) {
+	var tm TimedMap[string, int]
+	var calls AtomicInt64
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := tm.GetOrLoad(context.Background(), "k", loader, time.Minute); v != 42 || err != nil {
+				t.Errorf("unexpected %v %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected 1 call, got %d", n)
+	}
+
+	errBad := errors.New("bad")
+	failing := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		return 0, errBad
+	}
+	calls.Store(0)
+	for range 2 {
+		if _, err := tm.GetOrLoad(context.Background(), "err", failing, time.Minute); err != errBad {
+			t.Fatal("expected errBad, got", err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected errors not to be cached, got %d calls", n)
+	}
+
+	tm.SetNegativeTTL(time.Millisecond * 50)
+	calls.Store(0)
+	for range 2 {
+		if _, err := tm.GetOrLoad(context.Background(), "err", failing, time.Minute); err != errBad {
+			t.Fatal("expected errBad, got", err)
+		}
+	}
+	if _, ok := tm.GetOk("err"); ok || calls.Load() != 1 {
+		t.Fatalf("expected the error to be cached, got %d calls", calls.Load())
+	}
+	time.Sleep(time.Millisecond * 100)
+	tm.GetOrLoad(context.Background(), "err", failing, time.Minute)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected the cached error to expire, got %d calls", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	slow := func(ctx context.Context) (int, error) {
+		time.Sleep(time.Millisecond * 50)
+		return 1, ctx.Err()
+	}
+	if _, err := tm.GetOrLoad(ctx, "slow", slow, time.Minute); err != context.DeadlineExceeded {
+		t.Fatal("expected DeadlineExceeded, got", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if v := tm.Get("slow"); v != 1 {
+		t.Fatal("expected the loader to finish, got", v)
+	}
+}
+
+func TestTimedMapGetOrRefresh(t *testing.T) {
+	var tm TimedMap[string, int]
+	var calls AtomicInt64
+	var fail AtomicBool
+	errBad := errors.New("bad")
+	loader := func(ctx context.Context) (int, error) {
+		if fail.Load() {
+			return 0, errBad
+		}
+		return int(calls.Add(1)), nil
+	}
+	var refreshErrs AtomicInt64
+	tm.OnRefreshError(func(k string, err error) {
+		if err == errBad {
+			refreshErrs.Add(1)
+		}
+	})
+
+	get := func() int {
+		v, err := tm.GetOrRefresh(context.Background(), "k", loader, time.Millisecond*50, time.Millisecond*200)
+		DieIf(t, err)
+		return v
+	}
+
+	if v := get(); v != 1 {
+		t.Fatal("expected 1, got", v)
+	}
+	if v := get(); v != 1 {
+		t.Fatal("expected 1, got", v)
+	}
+	time.Sleep(time.Millisecond * 75)
+	if v := get(); v != 1 {
+		t.Fatal("expected the stale 1, got", v)
+	}
+	get()
+	time.Sleep(time.Millisecond * 20)
+	if v := get(); v != 2 || calls.Load() != 2 {
+		t.Fatal("expected a single refresh, got", v, calls.Load())
+	}
+
+	fail.Store(true)
+	time.Sleep(time.Millisecond * 75)
+	if v := get(); v != 2 {
+		t.Fatal("expected the stale 2, got", v)
+	}
+	time.Sleep(time.Millisecond * 20)
+	if refreshErrs.Load() != 1 {
+		t.Fatal("expected a refresh error, got", refreshErrs.Load())
+	}
+
+	time.Sleep(time.Millisecond * 200)
+	if _, ok := tm.GetOk("k"); ok {
+		t.Fatal("expected k to be removed after the hard ttl")
+	}
+	fail.Store(false)
+	if v := get(); v != 3 {
+		t.Fatal("expected 3, got", v)
+	}
+}
+
+func TestTimedMapClose(t *testing.T) {
+	n := runtime.NumGoroutine()
+	var tm TimedMap[int, int]
+	events, _ := tm.Events(1)
+	for i := range 100 {
+		tm.Set(i, i, time.Minute)
+		tm.SetUpdateFn(-i-1, func() int { return i }, time.Millisecond)
+	}
+	tm.GetOrRefresh(context.Background(), 1000, func(context.Context) (int, error) { return 1, nil }, time.Millisecond, time.Minute)
+	time.Sleep(time.Millisecond * 10)
+
+	DieIf(t, tm.Close())
+	assertTimedMapStopped(t, &tm, n)
+
+	for range events { // make sure Close closed the channel
+	}
+
+	tm.Set(1, 1, time.Minute)
+	tm.SetUpdateFn(2, func() int { return 2 }, time.Millisecond)
+	if _, err := tm.GetOrLoad(context.Background(), 3, func(context.Context) (int, error) { return 3, nil }, time.Minute); err != ErrTimedMapClosed {
+		t.Fatal("expected ErrTimedMapClosed, got", err)
+	}
+	if tm.Len() != 0 {
+		t.Fatal("expected Set to be a no-op after Close", tm.Len())
+	}
+	assertTimedMapStopped(t, &tm, n)
+}
+
+func TestTimedMapClear(t *testing.T) {
+	n := runtime.NumGoroutine()
+	var tm TimedMap[int, int]
+	for i := range 100 {
+		tm.Set(i, i, time.Minute)
+		tm.SetUpdateFn(-i-1, func() int { return i }, time.Millisecond)
+	}
+	tm.Clear()
+	assertTimedMapStopped(t, &tm, n)
+
+	tm.Set(1, 1, time.Minute)
+	if tm.Get(1) != 1 {
+		t.Fatal("expected the map to be usable after Clear")
+	}
+}
+
+func TestTimedMapAll(t *testing.T) {
+	var tm TimedMap[int, int]
+	defer tm.Close()
+	for i := range 10 {
+		tm.Set(i, i, time.Minute)
+	}
+	tm.Set(-1, -1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	n := 0
+	for k, v := range tm.All() {
+		if k != v || k < 0 {
+			t.Fatal("unexpected", k, v)
+		}
+		for i := range 10 { // deleting during the loop is allowed
+			if i != k {
+				tm.Delete(i)
+			}
+		}
+		n++
+	}
+	if n != 1 {
+		t.Fatal("expected 1 entry, got", n)
+	}
+	if st := tm.Stats(); st.Hits != 0 || st.Misses != 0 {
+		t.Fatalf("All shouldn't count hits or misses: %+v", st)
+	}
+}
+
+// assertTimedMapStopped fails if tm has pending timers or if there are more than n goroutines running,
+// goroutines are given some time to exit since running refresh funcs are allowed to finish.
+func assertTimedMapStopped[K comparable, V any](t *testing.T, tm *TimedMap[K, V], n int) {
+	t.Helper()
+	if timers, armed := tm.pending(); timers != 0 || armed {
+		t.Fatalf("timer leak: %d pending, armed: %v", timers, armed)
+	}
+	for range 100 {
+		if runtime.NumGoroutine() <= n {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("goroutine leak: %d > %d", runtime.NumGoroutine(), n)
+}
+
+func TestTimedMapSnapshot(t *testing.T) {
+	var tm TimedMap[string, int]
+	defer tm.Close()
+	tm.Set("forever", 1, 0)
+	tm.Set("ttl", 2, time.Millisecond*200)
+	tm.Set("expired", 3, time.Millisecond)
+	tm.SetUpdateExpireFn("upd", func() int { return 4 }, time.Minute, time.Hour)
+	tm.SetUpdateFn("noFn", func() int { return 5 }, time.Millisecond*200)
+	tm.SetNegativeTTL(time.Minute)
+	tm.GetOrLoad(context.Background(), "err", func(context.Context) (int, error) { return 0, errors.New("bad") }, time.Minute)
+	time.Sleep(time.Millisecond * 10)
+
+	jb, err := json.Marshal(&tm)
+	DieIf(t, err)
+	mb, err := MarshalMsgpack(&tm)
+	DieIf(t, err)
+
+	var calls AtomicInt64
+	var jtm, mtm TimedMap[string, int]
+	defer jtm.Close()
+	defer mtm.Close()
+	for _, tm := range []*TimedMap[string, int]{&jtm, &mtm} {
+		tm.RegisterUpdateFn("upd", func() int { return int(calls.Add(1)) + 40 })
+	}
+	DieIf(t, json.Unmarshal(jb, &jtm))
+	DieIf(t, UnmarshalMsgpack(mb, &mtm))
+
+	for _, tm := range []*TimedMap[string, int]{&jtm, &mtm} {
+		exp := map[string]int{"forever": 1, "ttl": 2, "upd": 4, "noFn": 5}
+		got := map[string]int{}
+		tm.ForEach(func(k string, v int) bool {
+			got[k] = v
+			return true
+		})
+		if !MapEqual(exp, got) || calls.Load() != 0 {
+			t.Fatalf("expected %v, got %v (%d)", exp, got, calls.Load())
+		}
+	}
+
+	time.Sleep(time.Millisecond * 250)
+	for _, tm := range []*TimedMap[string, int]{&jtm, &mtm} {
+		if _, ok := tm.GetOk("ttl"); ok {
+			t.Fatal("expected ttl to expire")
+		}
+		if _, ok := tm.GetOk("noFn"); ok {
+			t.Fatal("expected noFn to expire")
+		}
+		if v := tm.Get("upd"); v != 4 {
+			t.Fatal("expected upd to keep its value until the next update, got", v)
+		}
+	}
+}
+
+func TestTimedMapStats(t *testing.T) {
+	var tm TimedMap[int, int]
+	defer tm.Close()
+	tm.SetMaxEntries(2)
+	tm.Set(1, 1, time.Minute)
+	tm.Get(1)
+	tm.Get(2)
+	tm.MustGet(2, func() int { return 2 }, time.Minute)
+	tm.GetOrLoad(context.Background(), 3, func(context.Context) (int, error) { return 0, errors.New("bad") }, time.Minute)
+	tm.Set(2, 3, time.Minute)
+	tm.Set(3, 3, time.Minute)
+	tm.Delete(3)
+
+	s := tm.Stats()
+	exp := TimedMapStats{
+		Hits: 1, Misses: 3, Loads: 2, LoadErrors: 1, LoadTime: s.LoadTime, Size: 1,
+		Evictions: map[EvictReason]uint64{EvictExpired: 0, EvictIdle: 0, EvictDeleted: 1, EvictReplaced: 1, EvictCapacity: 1},
+	}
+	if !reflect.DeepEqual(s, exp) {
+		t.Fatalf("expected %+v, got %+v", exp, s)
+	}
+	if r := s.HitRatio(); r != 0.25 {
+		t.Fatal("expected 0.25, got", r)
+	}
+
+	var m map[string]any
+	DieIf(t, json.Unmarshal([]byte(tm.Expvar().String()), &m))
+	if ev, _ := m["evictions"].(map[string]any); ev["capacity"] != 1.0 {
+		t.Fatalf("unexpected expvar output: %v", m)
+	}
+
+	tm.ResetStats()
+	if s := tm.Stats(); s.Hits != 0 || s.Loads != 0 || s.Evictions[EvictDeleted] != 0 || s.Size != 1 {
+		t.Fatalf("unexpected stats after reset: %+v", s)
+	}
+}